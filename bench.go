@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand/v2"
+	"os"
+	"runtime"
+	"time"
+
+	"github.com/xeeynamo/go-psyq-signatures/internal/corpus"
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+// genBenchCorpus builds the synthetic text section and signatures of the
+// benchmark, one object per pattern of corpus.Generate.
+func genBenchCorpus(seed uint64, size, count int) ([]byte, []psyq.Signature) {
+	b, patterns := corpus.Generate(seed, size, count)
+	items := make([]psyq.Signature, 0, count)
+	for i, pattern := range patterns {
+		items = append(items, psyq.Signature{
+			Name:      fmt.Sprintf("BENCH%04d.OBJ", i),
+			Signature: pattern,
+			Labels:    []psyq.Labels{{Name: fmt.Sprintf("Bench%04d", i)}},
+		})
+	}
+	return b, items
+}
+
+// parsedProvider serves the same already parsed signatures for any version,
+// keeping the parsing out of the timed scans.
+type parsedProvider []psyq.Signature
+
+func (p parsedProvider) Signatures(context.Context, string) ([]psyq.Signature, error) {
+	return p, nil
+}
+
+// runBench implements the bench subcommand: it times the matcher over a
+// synthetic corpus so the impact of matcher changes can be compared across
+// machines and revisions.
+func runBench(args []string) {
+	fs := flag.NewFlagSet("bench", flag.ContinueOnError)
+	size := fs.Int("size", 2<<20, "size in bytes of the synthetic text section")
+	count := fs.Int("signatures", 2000, "number of synthetic signatures")
+	runs := fs.Int("runs", 3, "number of timed runs")
+	seed := fs.Uint64("seed", 1, "seed of the synthetic corpus")
+	files := fs.Int("files", 1, "also time scanning this many files in a row, with and without memoized signatures")
+	inputSize := fs.Int("input-size", 0, "also compare the heap used to read an input file of this size, with and without memory mapping")
+	parseFlags(fs, args)
+	if *size < 16 || *count < 1 || *runs < 1 || *files < 1 || *inputSize < 0 {
+		fmt.Fprintln(os.Stderr, "bench: size must be at least 16, signatures, runs and files at least 1")
+		os.Exit(1)
+	}
+
+	b, items := genBenchCorpus(*seed, *size, *count)
+	signatures, err := psyq.ParseSignatures(map[string][]psyq.Signature{"BENCH.json": items})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "bench:", err)
+		os.Exit(1)
+	}
+	best, matches := benchScans(b, parsedProvider(signatures), psyq.DefaultConcurrency, 1, *runs)
+	secs := best.Seconds()
+	fmt.Printf("size=%d signatures=%d matches=%d runs=%d\n", *size, *count, matches, *runs)
+	fmt.Printf("best=%s throughput=%.2f MB/s matches/sec=%.2f\n",
+		best.Round(time.Microsecond), float64(*size)/secs/(1<<20), float64(matches)/secs)
+	sequential, _ := benchScans(b, parsedProvider(signatures), 1, 1, *runs)
+	fmt.Printf("sequential=%s parallel=%s concurrency=%d\n",
+		sequential.Round(time.Microsecond), best.Round(time.Microsecond), psyq.DefaultConcurrency)
+	if *files > 1 {
+		static := psyq.StaticProvider{"bench": items}
+		parseEach, _ := benchScans(b, static, psyq.DefaultConcurrency, *files, *runs)
+		var memoized time.Duration
+		for i := 0; i < *runs; i++ {
+			// a fresh memoization per run, as every process starts empty
+			elapsed, _ := benchScans(b, psyq.Memoize(static), psyq.DefaultConcurrency, *files, 1)
+			if i == 0 || elapsed < memoized {
+				memoized = elapsed
+			}
+		}
+		fmt.Printf("files=%d parse-each=%s memoized=%s\n",
+			*files, parseEach.Round(time.Microsecond), memoized.Round(time.Microsecond))
+	}
+	if *inputSize > 0 {
+		if err := benchInput(*seed, *inputSize); err != nil {
+			fmt.Fprintln(os.Stderr, "bench:", err)
+			os.Exit(1)
+		}
+	}
+}
+
+// benchInput writes a synthetic input file of the given size and reports
+// the heap held while its content is in use, once read in full and once
+// through readInput, which memory maps files that large.
+func benchInput(seed uint64, size int) error {
+	r := rand.New(rand.NewPCG(seed, seed))
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(r.UintN(256))
+	}
+	f, err := os.CreateTemp("", "psyq-bench-*.bin")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	b = nil
+
+	before := heapAlloc()
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		return err
+	}
+	read := heapAlloc() - before
+	runtime.KeepAlive(data)
+	data = nil
+
+	before = heapAlloc()
+	data, done, err := readInput(f.Name())
+	if err != nil {
+		return err
+	}
+	defer done()
+	var sum byte
+	for _, c := range data {
+		sum += c // fault every page in, as a scan would
+	}
+	mapped := heapAlloc() - before
+	fmt.Printf("input=%d read-heap=%.2f MB mapped-heap=%.2f MB checksum=%02X\n",
+		size, float64(read)/(1<<20), float64(mapped)/(1<<20), sum)
+	return nil
+}
+
+// heapAlloc returns the bytes allocated on the heap and still reachable.
+func heapAlloc() int64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return int64(m.HeapAlloc)
+}
+
+// benchScans returns the best time out of runs to scan b as many times as
+// files, and the number of matches of a scan.
+func benchScans(b []byte, provider psyq.SignatureProvider, concurrency, files, runs int) (time.Duration, int) {
+	opts := psyq.Options{
+		Provider:    provider,
+		Versions:    []string{"bench"},
+		Concurrency: concurrency,
+	}
+	var best time.Duration
+	var matches int
+	for i := 0; i < runs; i++ {
+		start := time.Now()
+		for j := 0; j < files; j++ {
+			result, err := psyq.Scan(b, psyq.DefaultBaseAddr, opts)
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "bench:", err)
+				os.Exit(1)
+			}
+			matches = len(result.Matches)
+		}
+		elapsed := time.Since(start)
+		if i == 0 || elapsed < best {
+			best = elapsed
+		}
+	}
+	return best, matches
+}
@@ -2,4 +2,4 @@ module github.com/xeeynamo/go-psyq-signatures
 
 go 1.25.5
 
-require golang.org/x/sync v0.19.0 // indirect
+require golang.org/x/sync v0.19.0
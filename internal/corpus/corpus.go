@@ -0,0 +1,45 @@
+// Package corpus generates the synthetic text sections and signatures the
+// benchmarks scan.
+package corpus
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"strings"
+)
+
+// Generate deterministically builds a text section of the given size and
+// count signature patterns against it. Half of the patterns are cut from
+// the text itself, with a few wildcarded bytes, so they are guaranteed to
+// match; the other half are random and will most likely not.
+func Generate(seed uint64, size, count int) ([]byte, []string) {
+	r := rand.New(rand.NewPCG(seed, seed))
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = byte(r.UintN(256))
+	}
+	patterns := make([]string, 0, count)
+	for i := range count {
+		sigLen := min(16+4*r.IntN(60), size)
+		pattern := make([]byte, sigLen)
+		if i%2 == 0 {
+			copy(pattern, b[r.IntN(size-sigLen+1):])
+		} else {
+			for j := range pattern {
+				pattern[j] = byte(r.UintN(256))
+			}
+		}
+		tokens := make([]string, sigLen)
+		for j, c := range pattern {
+			tokens[j] = fmt.Sprintf("%02X", c)
+		}
+		// mask a 4-byte operand as the relocations in real signatures do
+		for j := 4; j+4 <= sigLen; j += 16 {
+			for k := j; k < j+4; k++ {
+				tokens[k] = "??"
+			}
+		}
+		patterns = append(patterns, strings.Join(tokens, " "))
+	}
+	return b, patterns
+}
@@ -1,293 +1,725 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
-	"net/http"
+	"io"
+	"log/slog"
+	"math"
 	"os"
-	"sort"
-	"strconv"
+	"os/signal"
+	"slices"
 	"strings"
-	"sync"
+	"time"
 
-	"golang.org/x/sync/errgroup"
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
 )
 
-type GitHubItem struct {
-	Name        string `json:"name"`
-	Path        string `json:"path"`
-	Type        string `json:"type"` // "file" or "dir"
-	Size        int    `json:"size"`
-	DownloadURL string `json:"download_url"`
+type options struct {
+	format           string
+	versions         []string
+	coverageByObject bool
+	gaps             int
+	snapEnds         bool
+	confidence       float64
+	resolveRelocs    bool
+	showVersions     bool
+	symbolsOnly      bool
+	segmentsOnly     bool
+	includePrefixes  []string
+	excludePrefixes  []string // DefaultExcludePrefixes when nil
+	verbose          bool
+	concurrency      int
+	minSymbols       int
+	strict           bool
+	failOnNoMatch    bool
+	dataRegions      []psyq.Region           // set per file from its header
+	baseline         map[string]*psyq.Result // by file, to diff the scans against
+	diag             io.Writer               // receives the text version estimates, w when nil
+	noHeader         bool
+	scanImage        bool
+	exeIndex         int     // of the executable to scan in a disc image, -1 when unset
+	start            *uint64 // file offset to scan from, start of text when nil
+	length           *uint64 // bytes to scan, until the end of file when nil
+	baseAddr         *uint32 // load address of the text section, from the header when nil
 }
 
-func fetchGitHubFolder(owner, repo, folder string) ([]GitHubItem, error) {
-	resp, err := http.Get(fmt.Sprintf("https://api.github.com/repos/lab313ru/psx_psyq_signatures/contents/%s", folder))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("GitHub API returned %s", resp.Status)
+// parseVersions parses a comma-separated list of SDK versions, rejecting
+// the ones without signatures. An empty list selects every version.
+func parseVersions(list string) ([]string, error) {
+	if list == "" {
+		return psyq.Versions, nil
 	}
-	var items []GitHubItem
-	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
-		return nil, err
+	var versions []string
+	for _, ver := range strings.Split(list, ",") {
+		ver = strings.TrimSpace(ver)
+		if !slices.Contains(psyq.Versions, ver) {
+			return nil, fmt.Errorf("unknown PSY-Q version %q, expected one of %s", ver, strings.Join(psyq.Versions, ","))
+		}
+		if !slices.Contains(versions, ver) {
+			versions = append(versions, ver)
+		}
 	}
-	return items, nil
+	return versions, nil
 }
 
-type Labels struct {
-	Name   string `json:"name"`
-	Offset uint32 `json:"offset"`
-}
-type Signature struct {
-	Name      string   `json:"name"`
-	Signature string   `json:"sig"`
-	Labels    []Labels `json:"labels,omitempty"`
-	Bss       []Labels `json:"xbss,omitempty"`
-	signature []byte
-	wildcard  []bool
+// errNoMatches reports a scan that identified nothing with
+// -fail-on-no-match, already logged.
+var errNoMatches = errors.New("no matches found")
+
+// The exit codes of a scan, 0 meaning every file was scanned.
+const (
+	exitError   = 1 // a file could not be scanned
+	exitNoMatch = 2 // with -fail-on-no-match, nothing was found in a file
+)
+
+// scanOptions returns the options of the library matching opts.
+func scanOptions(provider psyq.SignatureProvider, opts options, logger *slog.Logger) psyq.Options {
+	return psyq.Options{
+		Provider:            provider,
+		Versions:            opts.versions,
+		Concurrency:         opts.concurrency,
+		MinSymbols:          opts.minSymbols,
+		DataRegions:         opts.dataRegions,
+		CoverageByObject:    opts.coverageByObject,
+		Gaps:                opts.gaps,
+		SnapEnds:            opts.snapEnds,
+		ConfidenceThreshold: opts.confidence,
+		ResolveRelocs:       opts.resolveRelocs,
+		IncludePrefixes:     opts.includePrefixes,
+		ExcludePrefixes:     opts.excludePrefixes,
+		ExcludeAmbiguous:    opts.strict,
+		Logger:              logger,
+	}
 }
 
-func fetchPsyqSignatures(sdkver string) ([]Signature, error) {
-	files, err := fetchGitHubFolder("lab313ru", "psx_psyq_signatures", sdkver)
+// do scans b and writes the results to w. file names the input in the output
+// when several are scanned, and is empty otherwise.
+func do(ctx context.Context, w io.Writer, file string, b []byte, baseAddr uint32, provider psyq.SignatureProvider, opts options) error {
+	logger := slog.Default()
+	if file != "" {
+		logger = logger.With("file", file)
+	}
+	result, err := psyq.ScanContext(ctx, b, baseAddr, scanOptions(provider, opts, logger))
 	if err != nil {
-		return nil, err
+		return err
 	}
-	var signatures []Signature
-	var mu sync.Mutex
-	var eg errgroup.Group
-	for _, file := range files {
-		eg.Go(func() error {
-			resp, err := http.Get(file.DownloadURL)
-			if err != nil {
-				return err
-			}
-			defer resp.Body.Close()
-			if resp.StatusCode != http.StatusOK {
-				return fmt.Errorf("GitHub API returned %s", resp.Status)
+	if len(result.Matches) == 0 {
+		if opts.failOnNoMatch {
+			logger.Error("no matches found, is it a valid PSX EXE?")
+			return errNoMatches
+		}
+		logger.Warn("no matches found, is it a valid PSX EXE?")
+	}
+	for _, c := range result.Conflicts {
+		addrs := make([]string, len(c.Addresses))
+		for i, addr := range c.Addresses {
+			addrs[i] = fmt.Sprintf("0x%08X", addr)
+		}
+		logger.Warn("symbol defined at multiple addresses", "name", c.Name, "addresses", strings.Join(addrs, ","))
+	}
+	for _, a := range result.Ambiguities {
+		starts := make([]string, len(a.Starts))
+		for i, start := range a.Starts {
+			starts[i] = fmt.Sprintf("0x%X", start)
+		}
+		logger.Warn("object matched at multiple offsets", "name", a.Name, "offsets", strings.Join(starts, ","))
+	}
+	if opts.strict && len(result.Conflicts) > 0 {
+		return fmt.Errorf("%d conflicting symbol names", len(result.Conflicts))
+	}
+	diag := opts.diag
+	if diag == nil {
+		diag = w
+		if opts.symbolsOnly || opts.segmentsOnly {
+			// keep the output down to what was asked for
+			diag = os.Stderr
+		}
+	}
+	if opts.baseline != nil {
+		baseline, err := baselineOf(opts.baseline, file)
+		if err != nil {
+			return err
+		}
+		return writeDiff(w, file, psyq.DiffResults(baseline, result), opts.format)
+	}
+	if file != "" {
+		writeFileHeader(w, opts.format, file)
+		if diag != w && opts.format == "text" {
+			writeFileHeader(diag, opts.format, file)
+		}
+	}
+	if opts.format == "text" {
+		writeVersionEstimates(diag, result.Versions)
+		// stdout keeps the text it always had, the summary goes to stderr
+		summary := diag
+		if summary == w {
+			summary = os.Stderr
+			if file != "" {
+				writeFileHeader(summary, opts.format, file)
 			}
-			var items []Signature
-			if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		}
+		writeSummary(summary, result.Summary)
+		if (opts.symbolsOnly || opts.segmentsOnly) && len(result.Coverage) > 0 {
+			if err := writeCoverage(diag, result.Coverage); err != nil {
 				return err
 			}
-			mu.Lock()
-			defer mu.Unlock()
-			signatures = append(signatures, items...)
-			return nil
-		})
+		}
 	}
-	if err := eg.Wait(); err != nil {
+	return writeResult(w, &report{
+		File:         file,
+		Result:       result,
+		showVersions: opts.showVersions,
+		noSegments:   opts.symbolsOnly,
+		noSymbols:    opts.segmentsOnly,
+	}, opts.format)
+}
+
+// loadBaseline reads the results previously written by -format json, one
+// document per scanned file.
+func loadBaseline(path string) (map[string]*psyq.Result, error) {
+	f, err := os.Open(path)
+	if err != nil {
 		return nil, err
 	}
-	for i, signature := range signatures {
-		s := strings.Split(strings.ToLower(signature.Signature), " ")
-		for _, ch := range s {
-			if ch == "??" {
-				signature.wildcard = append(signature.wildcard, true)
-				signature.signature = append(signature.signature, 0)
-				continue
-			}
-			if ch == "" {
-				continue
-			}
-			b, err := strconv.ParseUint(ch, 16, 8)
-			if err != nil {
-				return nil, err
-			}
-			signature.wildcard = append(signature.wildcard, false)
-			signature.signature = append(signature.signature, byte(b))
+	defer f.Close()
+	baseline := map[string]*psyq.Result{}
+	dec := json.NewDecoder(f)
+	for {
+		var doc report
+		if err := dec.Decode(&doc); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("baseline %s: %w", path, err)
 		}
-		signatures[i] = signature
+		if doc.Result == nil {
+			return nil, fmt.Errorf("baseline %s: not a scan result", path)
+		}
+		baseline[doc.File] = doc.Result
+	}
+	if len(baseline) == 0 {
+		return nil, fmt.Errorf("baseline %s: no scan result", path)
 	}
-	return signatures, nil
+	return baseline, nil
 }
 
-func checkSignature(b []byte, signature Signature) (Signature, int) {
-	sigLen := len(signature.signature)
-	if sigLen == 0 {
-		return Signature{}, -1
-	}
-	for i := 0; i < len(b)-sigLen; i++ {
-		start := b[i:]
-		match := true
-		for j := 0; j < sigLen; j++ {
-			if signature.wildcard[j] {
-				continue
-			}
-			if start[j] != signature.signature[j] {
-				match = false
-				break
-			}
-		}
-		if match {
-			return signature, i
+// baselineOf returns the baseline result of file. A baseline of a single
+// result applies to any file, so renamed inputs still compare.
+func baselineOf(baseline map[string]*psyq.Result, file string) (*psyq.Result, error) {
+	if result, ok := baseline[file]; ok {
+		return result, nil
+	}
+	if len(baseline) == 1 {
+		for _, result := range baseline {
+			return result, nil
 		}
 	}
-	return Signature{}, -1
+	if file == "" {
+		return nil, fmt.Errorf("the baseline holds %d results, scan the same files to compare them", len(baseline))
+	}
+	return nil, fmt.Errorf("no baseline for %s", file)
 }
 
-type match struct {
-	start   int
-	end     int
-	name    string
-	version string
-	symbols map[uint32]string
+// mmapThreshold is the size from which input files are memory mapped
+// rather than read, as memory dumps and concatenated images can be large.
+const mmapThreshold = 4 << 20
+
+// readInput returns the whole content of the file at path, or of the
+// standard input when path is "-", and the function to call once done with
+// it. Large files are memory mapped when the platform allows it, and read
+// otherwise.
+func readInput(path string) ([]byte, func(), error) {
+	if path == "-" {
+		data, err := io.ReadAll(os.Stdin)
+		return data, func() {}, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.Mode().IsRegular() && info.Size() >= mmapThreshold && info.Size() <= math.MaxInt {
+		data, unmap, err := mmapFile(f, int(info.Size()))
+		if err == nil {
+			return data, func() { unmap() }, nil
+		}
+		slog.Debug("reading the file, unable to memory map it", "file", path, "err", err)
+	}
+	data, err := io.ReadAll(f)
+	return data, func() {}, err
 }
 
-func getMatches(b []byte, baseAddr uint32, sdkver string) []match {
-	signatures, err := fetchPsyqSignatures(sdkver)
+// scanFile scans the file at path, as selected by the options, and writes
+// its results to w. A path of "-" reads the standard input.
+func scanFile(ctx context.Context, w io.Writer, path, file string, provider psyq.SignatureProvider, opts options) error {
+	data, done, err := readInput(path)
 	if err != nil {
-		log.Fatal(err)
-	}
-	var matches []match
-	for _, signature := range signatures {
-		sig, offset := checkSignature(b, signature)
-		if offset < 0 {
-			continue
-		}
-		m := match{
-			start:   offset,
-			end:     offset + len(sig.signature),
-			name:    sig.Name,
-			version: sdkver,
-			symbols: map[uint32]string{},
-		}
-		for _, label := range sig.Labels {
-			if strings.HasPrefix(label.Name, "loc_") {
-				continue
-			}
-			if strings.HasPrefix(label.Name, "text_") {
-				continue
-			}
-			m.symbols[baseAddr+uint32(offset)+label.Offset] = label.Name
+		return err
+	}
+	defer done()
+	if opts.scanImage {
+		data, err = selectImageExe(data, opts.exeIndex)
+		if err != nil {
+			return err
 		}
-		matches = append(matches, m)
 	}
-	return matches
+	text, baseAddr, regions, err := selectText(data, opts)
+	if err != nil {
+		return err
+	}
+	opts.dataRegions = regions
+	return do(ctx, w, file, text, baseAddr, provider, opts)
 }
 
-type VersionEstimate struct {
-	version string
-	match   float64
+// selectImageExe returns the executable found in a disc image, or the one
+// at index when there are several.
+func selectImageExe(image []byte, index int) ([]byte, error) {
+	exes := psyq.FindExes(image)
+	switch {
+	case len(exes) == 0:
+		return nil, errors.New("no PS-EXE found in the disc image")
+	case index >= len(exes):
+		return nil, fmt.Errorf("executable %d requested, but the disc image has %d", index, len(exes))
+	case index < 0 && len(exes) > 1:
+		for i, exe := range exes {
+			slog.Info("executable found", "index", i,
+				"offset", fmt.Sprintf("0x%X", exe.Offset),
+				"addr", fmt.Sprintf("0x%08X", exe.Header.TAddr),
+				"size", fmt.Sprintf("0x%X", exe.Header.TSize))
+		}
+		return nil, fmt.Errorf("found %d executables in the disc image, pick one with -exe-index", len(exes))
+	}
+	exe := exes[max(index, 0)]
+	slog.Debug("scanning executable", "offset", fmt.Sprintf("0x%X", exe.Offset))
+	return exe.Extract(image)
 }
 
-func estimatePsyqVesion(matches map[string]match) []VersionEstimate {
-	versions := make(map[string]int)
-	for _, m := range matches {
-		versions[m.version]++
-	}
-	total := float64(len(matches))
-	out := make([]VersionEstimate, 0, len(versions))
-	for v, count := range versions {
-		out = append(out, VersionEstimate{
-			version: v,
-			match:   float64(count) / total,
-		})
+// selectText returns the part of the file content to scan, as selected by
+// the options, the address it is loaded at and the data regions the header
+// declares.
+func selectText(data []byte, opts options) ([]byte, uint32, []psyq.Region, error) {
+	textOffset, textEnd, baseAddr := 0, len(data), uint32(psyq.DefaultBaseAddr)
+	var regions []psyq.Region
+	if !opts.noHeader {
+		header, err := psyq.ParseExeHeader(data)
+		if err != nil {
+			return nil, 0, nil, err
+		}
+		regions = header.DataRegions()
+		textOffset, baseAddr = psyq.ExeHeaderSize, header.TAddr
+		// anything past the declared text size is padding or data, where
+		// matches can only be spurious
+		if header.TSize != 0 && uint64(header.TSize) < uint64(textEnd-textOffset) {
+			textEnd = textOffset + int(header.TSize)
+		}
 	}
-	sort.Slice(out, func(i, j int) bool {
-		return out[i].match < out[j].match
-	})
-	if len(out) >= 3 {
-		out = out[:3]
+	if opts.baseAddr != nil {
+		// the data sections move along with the text, the matches are
+		// checked against them at the overridden addresses
+		for i := range regions {
+			regions[i].Addr += *opts.baseAddr - baseAddr
+		}
+		baseAddr = *opts.baseAddr
+	}
+	text, offset, err := selectRange(data[:textEnd], textOffset, opts.start, opts.length)
+	if err != nil {
+		return nil, 0, nil, err
 	}
-	return out
+	if len(text) == 0 {
+		return nil, 0, nil, errors.New("nothing to scan, the selected range is empty")
+	}
+	return text, baseAddr + uint32(offset), regions, nil
 }
 
-func getMatchesSorted(matches map[string]match) []match {
-	out := make([]match, 0, len(matches))
-	for _, m := range matches {
-		out = append(out, m)
+// selectRange returns the part of the file to scan, starting from the text
+// section at textOffset unless start says otherwise, and its offset from
+// the beginning of the text section. data ends with the text section. A nil
+// start or length takes the default.
+func selectRange(data []byte, textOffset int, start, length *uint64) ([]byte, int, error) {
+	begin, end := uint64(textOffset), uint64(len(data))
+	if start != nil {
+		if *start < begin || *start > end {
+			return nil, 0, fmt.Errorf("start 0x%X is outside of the text section 0x%X-0x%X", *start, begin, end)
+		}
+		begin = *start
 	}
-	sort.Slice(out, func(i, j int) bool {
-		return out[i].start < out[j].start
-	})
-	return out
+	if length != nil {
+		if *length > end-begin {
+			return nil, 0, fmt.Errorf("range 0x%X+0x%X overflows the end of the text section 0x%X", begin, *length, end)
+		}
+		end = begin + *length
+	}
+	return data[begin:end], int(begin) - textOffset, nil
 }
 
-func getSymbolsSorted(matches map[string]match) []Labels {
-	var out []Labels
-	for _, m := range matches {
-		for offset, name := range m.symbols {
-			out = append(out, Labels{
-				Name:   name,
-				Offset: offset,
-			})
-		}
-	}
-	sort.Slice(out, func(i, j int) bool {
-		return out[i].Offset < out[j].Offset
-	})
-	return out
+// listSignatures writes how many signatures a version has and the objects
+// they belong to, sorted by library then object.
+func listSignatures(ctx context.Context, w io.Writer, provider psyq.SignatureProvider, version string) error {
+	signatures, err := provider.Signatures(ctx, version)
+	if err != nil {
+		return fmt.Errorf("PSY-Q %s: %w", version, err)
+	}
+	names := make([]string, len(signatures))
+	for i, sig := range signatures {
+		names[i] = sig.Library + "/" + psyq.NormalizeObjectName(sig.Name)
+	}
+	slices.Sort(names)
+	fmt.Fprintf(w, "PSY-Q %s: %d signatures\n", version, len(names))
+	for _, name := range names {
+		if _, err := fmt.Fprintln(w, name); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-func do(b []byte, baseAddr uint32) {
-	versions := []string{
-		"260", "300", "330", "340", "350", "3610", "3611", "370",
-		"400", "410", "420", "430", "440", "450", "460", "470",
-	}
-	var eg errgroup.Group
-	var mu sync.Mutex
-	allMatches := map[string]match{}
-	for _, ver := range versions {
-		eg.Go(func() error {
-			matches := getMatches(b, baseAddr, ver)
-			if len(matches) == 0 {
-				return nil
-			}
-			mu.Lock()
-			defer mu.Unlock()
-			for _, match := range matches {
-				existingMatch, ok := allMatches[match.name]
-				if !ok {
-					allMatches[match.name] = match
-					continue
-				}
-				// If the same match is found across different PSY-Q versions,
-				// take the match with the highest symbol matches found
-				if len(existingMatch.symbols) < len(match.symbols) {
-					allMatches[match.name] = match
-				}
-			}
-			return nil
-		})
+// isKseg0RAM tells if addr lies in the cached mirror of the RAM, up to the
+// 8MB of the development units, where executables are loaded.
+func isKseg0RAM(addr uint32) bool {
+	return addr >= 0x80000000 && addr < 0x80800000
+}
+
+// dumpSignatures writes the parsed signatures of a version as JSON, wildcard
+// masks included, as the matcher sees them.
+func dumpSignatures(ctx context.Context, w io.Writer, provider psyq.SignatureProvider, version string) error {
+	signatures, err := provider.Signatures(ctx, version)
+	if err != nil {
+		return fmt.Errorf("PSY-Q %s: %w", version, err)
+	}
+	parsed := make([]psyq.ParsedSignature, len(signatures))
+	for i := range signatures {
+		parsed[i] = signatures[i].Parsed()
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(parsed)
+}
+
+// parseRepo splits a GitHub repository written as owner/name.
+func parseRepo(repo string) (owner, name string, err error) {
+	owner, name, ok := strings.Cut(repo, "/")
+	if !ok || !isRepoName(owner) || !isRepoName(name) {
+		return "", "", fmt.Errorf("invalid repository %q, expected owner/name", repo)
 	}
-	_ = eg.Wait()
+	return owner, name, nil
+}
 
-	if len(allMatches) == 0 {
-		log.Fatal("mo matches found, is it a valid PSX EXE?")
+// isRepoName tells if s is a valid GitHub owner or repository name.
+func isRepoName(s string) bool {
+	if s == "" || s == "." || s == ".." {
+		return false
+	}
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_' || c == '.') {
+			return false
+		}
 	}
+	return true
+}
 
-	matches := getMatchesSorted(allMatches)
-	symbols := getSymbolsSorted(allMatches)
-	for _, ver := range estimatePsyqVesion(allMatches) {
-		fmt.Printf("PSY-Q %s: %.2f\n", ver.version, ver.match)
+// isBranchName tells if s is a ref git would accept as a branch name, see
+// git check-ref-format.
+func isBranchName(s string) bool {
+	if s == "" || s == "@" || strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") ||
+		strings.HasSuffix(s, ".") || strings.HasSuffix(s, ".lock") ||
+		strings.Contains(s, "..") || strings.Contains(s, "//") || strings.Contains(s, "@{") {
+		return false
 	}
-	fmt.Printf(" - [0x%X, c, %s]\n", matches[0].start, strings.ToLower(strings.TrimSuffix(matches[0].name, ".OBJ")))
-	for i := 1; i < len(matches); i++ {
-		if matches[i].start > matches[i-1].end {
-			fmt.Printf(" - [0x%X, c]\n", matches[i-1].end)
+	for _, part := range strings.Split(s, "/") {
+		if strings.HasPrefix(part, ".") {
+			return false
 		}
-		fmt.Printf(" - [0x%X, c, %s]\n", matches[i].start, strings.ToLower(strings.TrimSuffix(matches[i].name, ".OBJ")))
 	}
-	for _, symbol := range symbols {
-		fmt.Printf("%s = 0x%08X\n", symbol.Name, baseAddr+symbol.Offset)
+	for _, c := range s {
+		if c < 0x20 || c == 0x7F || strings.ContainsRune(" ~^:?*[\\", c) {
+			return false
+		}
 	}
+	return true
+}
+
+// parseFlags parses args into fs, set to flag.ContinueOnError, and exits
+// on an invalid flag with exitError rather than the status 2 of
+// flag.ExitOnError, which would read as exitNoMatch.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		os.Exit(exitError)
+	}
+}
+
+// fatal logs an error and exits.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(exitError)
 }
 
 func main() {
-	if len(os.Args) < 2 {
-		fmt.Printf("Usage: %s <psx.exe>\n", os.Args[0])
-		os.Exit(1)
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		runBench(os.Args[2:])
+		return
+	}
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	var opts options
+	flag.StringVar(&opts.format, "format", "text", "output format: "+strings.Join(formatNames(), ", "))
+	versions := flag.String("versions", "", "comma-separated list of PSY-Q versions to scan (default all)")
+	flag.BoolVar(&opts.noHeader, "no-header", false, "scan a headerless binary from offset 0, loaded at 0x80010000")
+	flag.BoolVar(&opts.scanImage, "scan-image", false, "scan the executable found in a disc image, raw .bin or .iso")
+	flag.IntVar(&opts.exeIndex, "exe-index", -1, "with -scan-image, the executable to scan when the image holds several, from 0")
+	start := flag.Uint64("start", 0, "file offset to start scanning from, hex accepted (default start of the text section)")
+	length := flag.Uint64("length", 0, "number of bytes to scan from -start, hex accepted (default until the end of the text section)")
+	baseAddr := flag.Uint64("base-addr", 0, "load address of the text section, hex accepted (default from the header, or 0x80010000)")
+	output := flag.String("output", "", "write the matches and symbols to this file instead of stdout")
+	baseline := flag.String("baseline", "", "instead of the result, write how the objects and symbols changed since this result saved with -format json, as text or json")
+	flag.IntVar(&opts.minSymbols, "min-symbols", 0, "drop the matches with fewer symbols than this, signatures without labels count as 0 symbols")
+	flag.BoolVar(&opts.failOnNoMatch, "fail-on-no-match", false, "exit with status 2 when nothing is found in a file, instead of writing an empty result")
+	flag.BoolVar(&opts.strict, "strict", false, "leave out the symbols of objects matched at more than one offset, and fail when a symbol name is still defined at more than one address")
+	flag.BoolVar(&opts.coverageByObject, "coverage-by-object", false, "report how many signatures of each matched library were found, with the text and json formats")
+	flag.Float64Var(&opts.confidence, "confidence-threshold", 0, "stop scanning the other versions once one covers this fraction of the text section, between 0 and 1 (default 0, scan them all)")
+	flag.BoolVar(&opts.snapEnds, "snap-ends", false, "extend every match over the alignment padding up to the start of the next one")
+	flag.IntVar(&opts.gaps, "gaps", 0, "list this many of the largest unmatched ranges in the coverage summary, written to stderr with the text format")
+	flag.BoolVar(&opts.symbolsOnly, "symbols-only", false, "only write the symbols in the text output, without the segments")
+	flag.BoolVar(&opts.segmentsOnly, "segments-only", false, "only write the segments in the text output, without the symbols")
+	flag.BoolVar(&opts.showVersions, "show-versions", false, "comment every text segment with the PSY-Q version it was matched from")
+	flag.BoolVar(&opts.resolveRelocs, "resolve-relocs", false, "decode the relocated operands of the matches, reporting unnamed jump targets as symbols")
+	flag.Func("include-prefix", "only report the labels with this prefix, can be repeated", func(prefix string) error {
+		opts.includePrefixes = append(opts.includePrefixes, prefix)
+		return nil
+	})
+	flag.Func("exclude-prefix", "do not report the labels with this prefix, can be repeated, an empty one excludes nothing (default "+strings.Join(psyq.DefaultExcludePrefixes, ", ")+")", func(prefix string) error {
+		if opts.excludePrefixes == nil {
+			opts.excludePrefixes = []string{}
+		}
+		if prefix != "" {
+			opts.excludePrefixes = append(opts.excludePrefixes, prefix)
+		}
+		return nil
+	})
+	flag.BoolVar(&opts.verbose, "verbose", false, "log diagnostic messages")
+	flag.IntVar(&opts.concurrency, "concurrency", psyq.DefaultConcurrency, "maximum number of concurrent downloads and version scans")
+	timeout := flag.Duration("timeout", 30*time.Second, "maximum duration of the whole scan, including downloads")
+	clearCache := flag.Bool("clear-cache", false, "delete the cached signatures and exit")
+	noCache := flag.Bool("no-cache", false, "always fetch the signatures from GitHub")
+	cacheTTL := flag.Duration("cache-ttl", 24*time.Hour, "how long cached signatures are considered fresh")
+	signaturesDir := flag.String("signatures-dir", "", "read the signatures from a local psx_psyq_signatures clone instead of GitHub")
+	skipInvalid := flag.Bool("skip-invalid", false, "skip the signatures with a malformed pattern, with a warning, instead of failing their version")
+	repo := flag.String("repo", psyq.DefaultOwner+"/"+psyq.DefaultRepo, "GitHub repository to download the signatures from, as owner/name")
+	branch := flag.String("branch", "", "branch of -repo to read (default the repository default branch)")
+	maxAttempts := flag.Int("max-attempts", psyq.DefaultMaxAttempts, "maximum attempts of a GitHub request failing with a transient error")
+	token := flag.String("token", "", "GitHub token to raise the API rate limit (default $GITHUB_TOKEN)")
+	dump := flag.String("json-signatures", "", "write the parsed signatures of this PSY-Q version as JSON and exit, without scanning")
+	serveAddr := flag.String("serve", "", "serve scans over HTTP on this address, such as :8080, instead of scanning files")
+	list := flag.String("list-signatures", "", "list the signatures available for this PSY-Q version and exit, without scanning")
+	flag.Usage = func() {
+		fmt.Printf("Usage: %s [flags] <psx.exe|->...\n", os.Args[0])
+		fmt.Printf("       %s -list-signatures <version> [flags]\n", os.Args[0])
+		fmt.Printf("       %s -json-signatures <version> [flags]\n", os.Args[0])
+		fmt.Printf("       %s -serve <addr> [flags]\n", os.Args[0])
+		fmt.Printf("       %s bench [flags]\n", os.Args[0])
+		fmt.Println()
+		fmt.Println("Exit status is 0 once every file is scanned, 1 on errors, and 2 when")
+		fmt.Println("-fail-on-no-match is set and nothing was found in a file.")
+		fmt.Println()
+		flag.PrintDefaults()
 	}
-	data, err := os.ReadFile(os.Args[1])
+	parseFlags(flag.CommandLine, os.Args[1:])
+	level := slog.LevelInfo
+	if opts.verbose {
+		level = slog.LevelDebug
+	}
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: level})))
+	if !isValidFormat(opts.format) {
+		fatal("unknown output format", "format", opts.format)
+	}
+	if opts.symbolsOnly && opts.segmentsOnly {
+		fatal("-symbols-only and -segments-only are mutually exclusive")
+	}
+	if opts.coverageByObject && opts.format != "text" && opts.format != "json" {
+		fatal("-coverage-by-object only supports the text and json formats", "format", opts.format)
+	}
+	if *branch != "" && !isBranchName(*branch) {
+		fatal("invalid branch name", "branch", *branch)
+	}
+	if opts.scanImage && opts.noHeader {
+		fatal("-scan-image and -no-header are mutually exclusive")
+	}
+	if opts.confidence < 0 || opts.confidence > 1 {
+		fatal("confidence threshold must be between 0 and 1")
+	}
+	if opts.concurrency < 1 {
+		fatal("concurrency must be at least 1")
+	}
+	var err error
+	opts.versions, err = parseVersions(*versions)
 	if err != nil {
-		log.Fatal(err)
+		fatal(err.Error())
+	}
+	if *baseline != "" {
+		if opts.format != "text" && opts.format != "json" {
+			fatal("-baseline only supports the text and json formats", "format", opts.format)
+		}
+		opts.baseline, err = loadBaseline(*baseline)
+		if err != nil {
+			fatal(err.Error())
+		}
+	}
+	if *clearCache {
+		cache, err := psyq.NewSignatureCache(*cacheTTL)
+		if err != nil {
+			fatal(err.Error())
+		}
+		n, err := cache.Clear()
+		if err != nil {
+			fatal("unable to clear the signature cache", "err", err)
+		}
+		fmt.Printf("removed %d cached versions\n", n)
+		return
+	}
+	if *list != "" {
+		if _, err := parseVersions(*list); err != nil || strings.Contains(*list, ",") {
+			fatal("-list-signatures takes a single PSY-Q version", "version", *list)
+		}
+	} else if *dump != "" {
+		if _, err := parseVersions(*dump); err != nil || strings.Contains(*dump, ",") {
+			fatal("-json-signatures takes a single PSY-Q version", "version", *dump)
+		}
+	} else if *serveAddr == "" && flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+	stdin := 0
+	for _, path := range flag.Args() {
+		if path == "-" {
+			stdin++
+		}
+	}
+	if stdin > 1 {
+		fatal("the standard input can only be scanned once")
+	}
+	var provider psyq.SignatureProvider
+	if *signaturesDir != "" {
+		provider = &psyq.LocalProvider{
+			Dir:         *signaturesDir,
+			SkipInvalid: *skipInvalid,
+			Logger:      slog.Default(),
+		}
+	} else {
+		if *token == "" {
+			*token = os.Getenv("GITHUB_TOKEN")
+		}
+		var cache *psyq.SignatureCache
+		if !*noCache {
+			cache, err = psyq.NewSignatureCache(*cacheTTL)
+			if err != nil {
+				slog.Warn("signature cache disabled", "err", err)
+			}
+		}
+		owner, name, err := parseRepo(*repo)
+		if err != nil {
+			fatal(err.Error())
+		}
+		provider = psyq.NewGitHubProvider(psyq.GitHubOptions{
+			Owner:       owner,
+			Repo:        name,
+			Branch:      *branch,
+			Token:       *token,
+			Concurrency: opts.concurrency,
+			MaxAttempts: *maxAttempts,
+			SkipInvalid: *skipInvalid,
+			Cache:       cache,
+			Logger:      slog.Default(),
+		})
+	}
+	provider = psyq.Memoize(provider)
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "start":
+			opts.start = start
+		case "length":
+			opts.length = length
+		case "base-addr":
+			if *baseAddr > math.MaxUint32 {
+				fatal("base address does not fit in 32 bits", "base-addr", fmt.Sprintf("0x%X", *baseAddr))
+			}
+			addr := uint32(*baseAddr)
+			if !isKseg0RAM(addr) {
+				slog.Warn("base address is outside of the KSEG0 RAM range 0x80000000-0x807FFFFF", "base-addr", fmt.Sprintf("0x%08X", addr))
+			}
+			opts.baseAddr = &addr
+		}
+	})
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			fatal(err.Error())
+		}
+		defer f.Close()
+		w = f
+		opts.diag = os.Stderr
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if *serveAddr != "" {
+		// the timeout applies to every scan rather than to the server
+		if err := serve(ctx, *serveAddr, provider, opts, *timeout); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	if *list != "" {
+		if err := listSignatures(ctx, w, provider, *list); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+	if *dump != "" {
+		if err := dumpSignatures(ctx, w, provider, *dump); err != nil {
+			fatal(err.Error())
+		}
+		return
+	}
+	if opts.confidence == 0 {
+		// fetch and parse every version before the first match, so the
+		// scans of all the files run purely in memory; with a confidence
+		// threshold they are fetched lazily, to skip the unneeded ones
+		db, err := psyq.LoadDatabase(ctx, provider, opts.versions, opts.concurrency)
+		if err != nil {
+			fatal(err.Error())
+		}
+		provider = db
+	}
+	failed, unmatched := false, false
+	for _, path := range flag.Args() {
+		file := ""
+		if flag.NArg() > 1 {
+			file = path
+		}
+		err := scanFile(ctx, w, path, file, provider, opts)
+		switch {
+		case errors.Is(err, errNoMatches):
+			unmatched = true
+		case err != nil:
+			slog.Error(err.Error(), "file", path)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(exitError)
 	}
-	if len(data) <= 0x800 {
-		log.Fatal("file too small?")
+	if unmatched {
+		os.Exit(exitNoMatch)
 	}
-	do(data[0x800:], 0x80010000)
 }
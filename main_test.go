@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+// testExe is the header of a PS-EXE loaded at 0x80010000.
+type testExe struct {
+	tSize  uint32 // declared text size, len(text) when zero
+	dAddr  uint32
+	dSize  uint32
+	bAddr  uint32
+	bSize  uint32
+	text   []byte
+	append []byte // after the text, beyond the declared size
+}
+
+func (e testExe) build() []byte {
+	b := make([]byte, psyq.ExeHeaderSize, psyq.ExeHeaderSize+len(e.text)+len(e.append))
+	copy(b, "PS-X EXE")
+	tSize := e.tSize
+	if tSize == 0 {
+		tSize = uint32(len(e.text))
+	}
+	binary.LittleEndian.PutUint32(b[0x18:], 0x80010000)
+	binary.LittleEndian.PutUint32(b[0x1C:], tSize)
+	binary.LittleEndian.PutUint32(b[0x20:], e.dAddr)
+	binary.LittleEndian.PutUint32(b[0x24:], e.dSize)
+	binary.LittleEndian.PutUint32(b[0x28:], e.bAddr)
+	binary.LittleEndian.PutUint32(b[0x2C:], e.bSize)
+	b = append(b, e.text...)
+	return append(b, e.append...)
+}
+
+// testPattern is the signature of TEST.OBJ, matching testCode.
+var (
+	testCode    = []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	testPattern = psyq.Signature{Name: "TEST.OBJ", Signature: "11 22 33 44 55 66 77 88", Labels: []psyq.Labels{{Name: "Test"}}}
+)
+
+// withCode returns size zero bytes with testCode at every offset given.
+func withCode(size int, offsets ...int) []byte {
+	b := make([]byte, size)
+	for _, offset := range offsets {
+		copy(b[offset:], testCode)
+	}
+	return b
+}
+
+// scanExe selects the text of exe as the command does and scans it for
+// testPattern.
+func scanExe(t *testing.T, exe []byte, opts options) *psyq.Result {
+	t.Helper()
+	text, baseAddr, regions, err := selectText(exe, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.dataRegions = regions
+	provider := psyq.StaticProvider{"400": {testPattern}}
+	opts.versions = []string{"400"}
+	result, err := psyq.ScanContext(context.Background(), text, baseAddr, scanOptions(provider, opts, nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// TestMain runs the command instead of the tests when PSYQ_TEST_EXEC_MAIN
+// is set, for runMain to check its exit status.
+func TestMain(m *testing.M) {
+	if os.Getenv("PSYQ_TEST_EXEC_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runMain runs the command with args, reading the signatures of 400 from
+// a directory holding testPattern unless it is the bench subcommand, and
+// returns its exit status.
+func runMain(t *testing.T, args ...string) int {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(dir, "400"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	lib := `[{"name":"TEST.OBJ","sig":"11 22 33 44 55 66 77 88","labels":[{"name":"Test","offset":0}]}]`
+	if err := os.WriteFile(filepath.Join(dir, "400", "LIBTEST.json"), []byte(lib), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if len(args) == 0 || args[0] != "bench" {
+		args = append([]string{"-signatures-dir", dir, "-versions", "400"}, args...)
+	}
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "PSYQ_TEST_EXEC_MAIN=1")
+	var exit *exec.ExitError
+	if err := cmd.Run(); errors.As(err, &exit) {
+		return exit.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return 0
+}
+
+func TestSelectTextStopsAtTextSize(t *testing.T) {
+	// the object at 0x1FC runs 4 bytes into the padding after t_size
+	body := withCode(0x300, 0x10, 0x1FC)
+	exe := testExe{text: body[:0x200], append: body[0x200:]}.build()
+	text, _, _, err := selectText(exe, options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(text) != 0x200 {
+		t.Errorf("selected 0x%X bytes, want t_size 0x200", len(text))
+	}
+	result := scanExe(t, exe, options{})
+	if len(result.Matches) != 1 || result.Matches[0].Start != 0x10 {
+		t.Errorf("matches %+v, want only the one at 0x10", result.Matches)
+	}
+	whole := testExe{text: body}.build()
+	if result := scanExe(t, whole, options{}); len(result.Matches) != 2 {
+		t.Errorf("%d matches without padding, want 2", len(result.Matches))
+	}
+}
+
+func TestSelectTextDataRegions(t *testing.T) {
+	// the data section covers 0x100-0x200 of the text, the object at 0xFC
+	// runs into it and the one at 0x150 is in the middle of it
+	exe := testExe{dAddr: 0x80010100, dSize: 0x100, text: withCode(0x300, 0x10, 0xFC, 0x150, 0x210)}.build()
+	// moving the text moves the data section, which must not fall onto
+	// the object at 0x210 instead
+	base := uint32(0x8000FF00)
+	for _, opts := range []options{{}, {baseAddr: &base}} {
+		result := scanExe(t, exe, opts)
+		var starts []int
+		for _, m := range result.Matches {
+			starts = append(starts, m.Start)
+		}
+		if !slices.Equal(starts, []int{0x10, 0x210}) {
+			t.Errorf("base %v: matches at %X, want 0x10 and 0x210", opts.baseAddr != nil, starts)
+		}
+	}
+}
+
+func TestOutputDeterministic(t *testing.T) {
+	// every version finds the same objects, so only the tie-breaks decide
+	other := psyq.Signature{Name: "OTHER.OBJ", Signature: "55 66 77 88", Labels: []psyq.Labels{{Name: "Other"}, {Name: "Other2", Offset: 2}}}
+	provider := psyq.StaticProvider{}
+	for _, ver := range psyq.Versions {
+		provider[ver] = []psyq.Signature{testPattern, other}
+	}
+	text := withCode(0x400, 0x10, 0x100, 0x300)
+	for _, format := range formatNames() {
+		opts := options{format: format, concurrency: psyq.DefaultConcurrency, diag: io.Discard}
+		var first string
+		for i := range 20 {
+			var sb strings.Builder
+			if err := do(context.Background(), &sb, "", text, 0x80010000, provider, opts); err != nil {
+				t.Fatal(err)
+			}
+			if i == 0 {
+				first = sb.String()
+			} else if sb.String() != first {
+				t.Fatalf("%s: scan %d differs from the first one", format, i)
+			}
+		}
+	}
+}
+
+func TestSummaryNotOnStdout(t *testing.T) {
+	provider := psyq.StaticProvider{"400": {testPattern}}
+	opts := options{format: "text", versions: []string{"400"}}
+	var sb strings.Builder
+	if err := do(context.Background(), &sb, "", withCode(0x100, 0x10), 0x80010000, provider, opts); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sb.String(), "matched 0x") {
+		t.Errorf("summary written with the result:\n%s", sb.String())
+	}
+	want := "PSY-Q 400: 1.00\n - [0x10, c, test]\nTest = 0x80010010\n"
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
+
+func TestExitStatus(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, exe []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, exe, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	matched := write("matched.exe", testExe{text: withCode(0x100, 0x10)}.build())
+	empty := write("empty.exe", testExe{text: withCode(0x100)}.build())
+	missing := filepath.Join(dir, "missing.exe")
+	tests := []struct {
+		name string
+		args []string
+		want int
+	}{
+		{"matched", []string{matched}, 0},
+		{"nothing found", []string{empty}, 0},
+		{"fail on no match", []string{"-fail-on-no-match", matched, empty}, exitNoMatch},
+		{"fail on no match, matched", []string{"-fail-on-no-match", matched}, 0},
+		{"unreadable", []string{matched, missing}, exitError},
+		{"unreadable before no match", []string{"-fail-on-no-match", empty, missing}, exitError},
+		{"bad format", []string{"-format", "nope", matched}, exitError},
+		{"bad flag value", []string{"-min-symbols", "abc", matched}, exitError},
+		{"unknown flag", []string{"-nope", matched}, exitError},
+		{"help", []string{"-h"}, 0},
+		{"bad bench flag", []string{"bench", "-runs", "abc"}, exitError},
+		{"bench help", []string{"bench", "-h"}, 0},
+		{"traversal branch", []string{"-branch", "../../..", matched}, exitError},
+		{"coverage without a table", []string{"-coverage-by-object", "-format", "splat", matched}, exitError},
+		{"coverage in json", []string{"-coverage-by-object", "-format", "json", matched}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := runMain(t, tt.args...); got != tt.want {
+				t.Errorf("exit status %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIsBranchName(t *testing.T) {
+	for _, tt := range []struct {
+		branch string
+		want   bool
+	}{
+		{"main", true},
+		{"feature/scan-v2", true},
+		{"v4.7", true},
+		{"../../..", false},
+		{"a/../b", false},
+		{"/main", false},
+		{"main/", false},
+		{`a\b`, false},
+		{"a b", false},
+		{"a\x00b", false},
+		{".hidden", false},
+		{"a/.b", false},
+		{"main.lock", false},
+		{"main@{1}", false},
+		{"@", false},
+	} {
+		if got := isBranchName(tt.branch); got != tt.want {
+			t.Errorf("isBranchName(%q) = %v, want %v", tt.branch, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+// segmentName returns the name used for an object in the splat segments.
+func segmentName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(psyq.NormalizeObjectName(name), ".OBJ"))
+}
+
+// report is a scan result as written out, tagged with the input it comes
+// from when several are scanned.
+type report struct {
+	File string `json:"file,omitempty"`
+	*psyq.Result
+	showVersions bool // comment the text segments with their SDK version
+	noSegments   bool // leave the segments out of the text output
+	noSymbols    bool // leave the symbols out of the text output
+}
+
+var resultWriters = map[string]func(io.Writer, *report) error{
+	"text":      writeText,
+	"json":      writeJSON,
+	"nopsx-sym": writeNopsxSym,
+	"ghidra":    writeGhidra,
+	"splat":     writeSplat,
+	"idc":       writeIDC,
+	"grouped":   writeGrouped,
+}
+
+// formatNames lists the supported output formats.
+func formatNames() []string {
+	return slices.Sorted(maps.Keys(resultWriters))
+}
+
+func isValidFormat(format string) bool {
+	_, ok := resultWriters[format]
+	return ok
+}
+
+// commentPrefixes is the line comment syntax of the formats that have one.
+var commentPrefixes = map[string]string{
+	"text":      "#",
+	"nopsx-sym": ";",
+	"ghidra":    "#",
+	"splat":     "#",
+	"idc":       "//",
+	"grouped":   "#",
+}
+
+// writeFileHeader writes a comment line naming the input the following
+// results belong to. JSON documents carry the name in their file field.
+func writeFileHeader(w io.Writer, format, file string) {
+	if prefix, ok := commentPrefixes[format]; ok {
+		fmt.Fprintf(w, "%s %s\n", prefix, file)
+	}
+}
+
+func writeResult(w io.Writer, result *report, format string) error {
+	write, ok := resultWriters[format]
+	if !ok {
+		return fmt.Errorf("unknown output format %q", format)
+	}
+	return write(w, result)
+}
+
+// writeVersionEstimates prints the most likely SDK versions. The text format
+// keeps them apart from the result, as they are not part of the segments.
+func writeVersionEstimates(w io.Writer, versions []psyq.VersionEstimate) {
+	for _, ver := range versions {
+		fmt.Fprintf(w, "PSY-Q %s: %.2f\n", ver.Version, ver.Match)
+	}
+}
+
+// writeSummary prints how much of the scanned data was identified, and the
+// largest gaps left, along with the version estimates.
+func writeSummary(w io.Writer, summary psyq.Summary) {
+	fmt.Fprintf(w, "matched 0x%X of 0x%X bytes (%.2f%%)\n",
+		summary.Matched, summary.Size, 100*float64(summary.Matched)/float64(summary.Size))
+	for _, gap := range summary.Gaps {
+		fmt.Fprintf(w, "gap 0x%X, 0x%X bytes\n", gap.Start, gap.Length)
+	}
+}
+
+func writeText(w io.Writer, result *report) error {
+	if !result.noSegments {
+		writeSegments(w, result)
+	}
+	if !result.noSymbols {
+		writeSymbols(w, result.Symbols)
+	}
+	// with either left out, the coverage table goes to the diagnostics
+	if len(result.Coverage) > 0 && !result.noSegments && !result.noSymbols {
+		return writeCoverage(w, result.Coverage)
+	}
+	return nil
+}
+
+// writeSegments writes the matches as splat segments, with unnamed ones
+// filling the gaps between them.
+func writeSegments(w io.Writer, result *report) {
+	matches := result.Matches
+	for i, m := range matches {
+		if i > 0 && m.Start > matches[i-1].End {
+			fmt.Fprintf(w, " - [0x%X, c]\n", matches[i-1].End)
+		}
+		if result.showVersions {
+			fmt.Fprintf(w, " - [0x%X, c, %s] # PSY-Q %s\n", m.Start, segmentName(m.Name), m.Version)
+			continue
+		}
+		fmt.Fprintf(w, " - [0x%X, c, %s]\n", m.Start, segmentName(m.Name))
+	}
+}
+
+// writeSymbols writes a linker script line for every symbol.
+func writeSymbols(w io.Writer, symbols []psyq.Symbol) {
+	for _, symbol := range symbols {
+		if symbol.Kind == psyq.SymbolBss {
+			fmt.Fprintf(w, "%s = 0x%08X // bss\n", symbol.Name, symbol.Address)
+			continue
+		}
+		if symbol.Kind == psyq.SymbolReloc {
+			fmt.Fprintf(w, "%s = 0x%08X // reloc\n", symbol.Name, symbol.Address)
+			continue
+		}
+		fmt.Fprintf(w, "%s = 0x%08X\n", symbol.Name, symbol.Address)
+	}
+}
+
+// writeCoverage writes the table of -coverage-by-object.
+func writeCoverage(w io.Writer, coverage []psyq.ObjectCoverage) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LIBRARY\tFOUND\tTOTAL\tCOVERAGE")
+	for _, c := range coverage {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f\n", c.Library, c.Found, c.Total, float64(c.Found)/float64(c.Total))
+	}
+	return tw.Flush()
+}
+
+// writeSplat writes the matches as a splat segments list, with unnamed c
+// segments filling the gaps between them. When several inputs are scanned
+// each one is a YAML document of its own.
+func writeSplat(w io.Writer, result *report) error {
+	if result.File != "" {
+		fmt.Fprintln(w, "---")
+	}
+	fmt.Fprintln(w, "segments:")
+	matches := result.Matches
+	for i, m := range matches {
+		if i > 0 && m.Start > matches[i-1].End {
+			fmt.Fprintf(w, "  - type: c\n    start: 0x%X\n", matches[i-1].End)
+		}
+		if _, err := fmt.Fprintf(w, "  - name: %s\n    type: c\n    start: 0x%X\n", segmentName(m.Name), m.Start); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeIDC writes an IDA Pro IDC script creating a function over every
+// match, named after its object, then naming every symbol.
+func writeIDC(w io.Writer, result *report) error {
+	fmt.Fprintln(w, "// IDA script generated by go-psyq-signatures")
+	fmt.Fprintln(w, "#include <idc.idc>")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "static main() {")
+	for _, m := range result.Matches {
+		start, end := result.BaseAddr+uint32(m.Start), result.BaseAddr+uint32(m.End)
+		fmt.Fprintf(w, "\tadd_func(0x%08X, 0x%08X);\n", start, end)
+		fmt.Fprintf(w, "\tset_name(0x%08X, %s, SN_NOWARN);\n", start, strconv.Quote(segmentName(m.Name)))
+	}
+	for _, symbol := range result.Symbols {
+		fmt.Fprintf(w, "\tset_name(0x%08X, %s, SN_NOWARN);\n", symbol.Address, strconv.Quote(symbol.Name))
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// writeGrouped writes the matches nested under the library and the object
+// they come from, sorted by name, each with the symbols it defines.
+func writeGrouped(w io.Writer, result *report) error {
+	libraries := map[string]map[string][]psyq.Match{}
+	for _, m := range result.Matches {
+		if libraries[m.Library] == nil {
+			libraries[m.Library] = map[string][]psyq.Match{}
+		}
+		libraries[m.Library][m.Name] = append(libraries[m.Library][m.Name], m)
+	}
+	for _, library := range slices.Sorted(maps.Keys(libraries)) {
+		fmt.Fprintln(w, library)
+		objects := libraries[library]
+		for _, object := range slices.Sorted(maps.Keys(objects)) {
+			fmt.Fprintf(w, "  %s\n", object)
+			for _, m := range objects[object] {
+				fmt.Fprintf(w, "    [0x%X, 0x%X] PSY-Q %s\n", m.Start, m.End, m.Version)
+				for _, addr := range slices.Sorted(maps.Keys(m.Symbols)) {
+					fmt.Fprintf(w, "      %s = 0x%08X\n", m.Symbols[addr], addr)
+				}
+				for _, addr := range slices.Sorted(maps.Keys(m.Bss)) {
+					fmt.Fprintf(w, "      %s = 0x%08X // bss\n", m.Bss[addr], addr)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func writeJSON(w io.Writer, result *report) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(result)
+}
+
+// writeNopsxSym writes a .sym file loadable by the no$psx debugger.
+func writeNopsxSym(w io.Writer, result *report) error {
+	for _, symbol := range result.Symbols {
+		if _, err := fmt.Fprintf(w, "%08X %s\n", symbol.Address, symbol.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeGhidra writes a Ghidra Python script creating a function at the start
+// of every match and a label for every symbol.
+func writeGhidra(w io.Writer, result *report) error {
+	fmt.Fprintln(w, "# Ghidra script generated by go-psyq-signatures")
+	fmt.Fprintln(w, "#@category PSY-Q")
+	for _, m := range result.Matches {
+		addr := result.BaseAddr + uint32(m.Start)
+		fmt.Fprintf(w, "createFunction(toAddr(0x%08X), %s)\n", addr, strconv.Quote(segmentName(m.Name)))
+	}
+	for _, symbol := range result.Symbols {
+		if _, err := fmt.Fprintf(w, "createLabel(toAddr(0x%08X), %s, True)\n", symbol.Address, strconv.Quote(symbol.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiff writes how the objects and symbols of file changed since the
+// baseline, one line per object then per symbol prefixed by +, - or > for
+// the added, removed and moved ones, or as a JSON document.
+func writeDiff(w io.Writer, file string, diff psyq.Diff, format string) error {
+	if format == "json" {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(struct {
+			File string `json:"file,omitempty"`
+			psyq.Diff
+		}{file, diff})
+	}
+	if file != "" {
+		writeFileHeader(w, format, file)
+	}
+	if diff.Empty() {
+		_, err := fmt.Fprintln(w, "# no changes")
+		return err
+	}
+	for _, object := range diff.RemovedObjects {
+		fmt.Fprintf(w, "- %08X %s\n", object.Address, object.Name)
+	}
+	for _, object := range diff.AddedObjects {
+		fmt.Fprintf(w, "+ %08X %s\n", object.Address, object.Name)
+	}
+	for _, move := range diff.MovedObjects {
+		fmt.Fprintf(w, "> %08X %08X %s\n", move.From, move.To, move.Name)
+	}
+	for _, symbol := range diff.Removed {
+		fmt.Fprintf(w, "- %08X %s\n", symbol.Address, symbol.Name)
+	}
+	for _, symbol := range diff.Added {
+		fmt.Fprintf(w, "+ %08X %s\n", symbol.Address, symbol.Name)
+	}
+	for _, move := range diff.Moved {
+		if _, err := fmt.Fprintf(w, "> %08X %08X %s\n", move.From, move.To, move.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+// testReport returns the result of a scan finding A and B next to each
+// other in LIBA, C of LIBC after a gap, then B again.
+func testReport() *report {
+	return &report{Result: &psyq.Result{
+		BaseAddr: 0x80010000,
+		Matches: []psyq.Match{
+			{Name: "A.OBJ", Library: "LIBA", Version: "400", Start: 0x100, End: 0x140,
+				Symbols: map[uint32]string{0x80010100: "FuncA"}},
+			{Name: "B.OBJ", Library: "LIBA", Version: "400", Start: 0x140, End: 0x180,
+				Symbols: map[uint32]string{0x80010140: "FuncB"},
+				Bss:     map[uint32]string{0x80090000: "bssB"}},
+			{Name: "C.OBJ", Library: "LIBC", Version: "440", Start: 0x200, End: 0x220,
+				Symbols: map[uint32]string{0x80010200: "FuncC", 0x80010210: "FuncC2"}},
+			{Name: "B.OBJ", Library: "LIBA", Version: "400", Start: 0x300, End: 0x340,
+				Symbols: map[uint32]string{0x80010300: "FuncB"}},
+		},
+		Symbols: []psyq.Symbol{
+			{Name: "FuncA", Address: 0x80010100, Kind: psyq.SymbolText},
+			{Name: "FuncB", Address: 0x80010140, Kind: psyq.SymbolText},
+			{Name: "FuncC", Address: 0x80010200, Kind: psyq.SymbolText},
+			{Name: "FuncC2", Address: 0x80010210, Kind: psyq.SymbolText},
+			{Name: "FuncB", Address: 0x80010300, Kind: psyq.SymbolText},
+			{Name: "bssB", Address: 0x80090000, Kind: psyq.SymbolBss},
+		},
+	}}
+}
+
+// writeTest returns what write outputs for r.
+func writeTest(t *testing.T, write func(io.Writer, *report) error, r *report) string {
+	t.Helper()
+	var sb strings.Builder
+	if err := write(&sb, r); err != nil {
+		t.Fatal(err)
+	}
+	return sb.String()
+}
+
+func TestWriteNopsxSym(t *testing.T) {
+	got := writeTest(t, writeNopsxSym, testReport())
+	want := "80010100 FuncA\n" +
+		"80010140 FuncB\n" +
+		"80010200 FuncC\n" +
+		"80010210 FuncC2\n" +
+		"80010300 FuncB\n" +
+		"80090000 bssB\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteGhidra(t *testing.T) {
+	r := testReport()
+	got := writeTest(t, writeGhidra, r)
+	if n := strings.Count(got, "createFunction("); n != len(r.Matches) {
+		t.Errorf("%d createFunction calls, want one per match, %d", n, len(r.Matches))
+	}
+	if n := strings.Count(got, "createLabel("); n != len(r.Symbols) {
+		t.Errorf("%d createLabel calls, want one per symbol, %d", n, len(r.Symbols))
+	}
+	if !strings.Contains(got, `createFunction(toAddr(0x80010200), "c")`) {
+		t.Errorf("no function created for C.OBJ in\n%s", got)
+	}
+}
+
+var update = flag.Bool("update", false, "rewrite the golden files of the tests")
+
+// golden compares got to the content of testdata/name, rewriting it
+// instead with -update.
+func golden(t *testing.T, name, got string) {
+	t.Helper()
+	path := filepath.Join("testdata", name)
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != string(want) {
+		t.Errorf("%s differs, got\n%s", path, got)
+	}
+}
+
+// parseSegments parses the segments list written by writeSplat, as a list
+// of mappings, failing on any YAML it does not write.
+func parseSegments(t *testing.T, doc string) []map[string]string {
+	t.Helper()
+	lines := strings.Split(strings.TrimSuffix(doc, "\n"), "\n")
+	if len(lines) == 0 || lines[0] != "segments:" {
+		t.Fatalf("no segments key in\n%s", doc)
+	}
+	var segments []map[string]string
+	for _, line := range lines[1:] {
+		entry, ok := strings.CutPrefix(line, "  - ")
+		if ok {
+			segments = append(segments, map[string]string{})
+		} else if entry, ok = strings.CutPrefix(line, "    "); !ok || len(segments) == 0 {
+			t.Fatalf("unexpected line %q", line)
+		}
+		key, value, ok := strings.Cut(entry, ": ")
+		if !ok {
+			t.Fatalf("not a key: value pair %q", line)
+		}
+		segments[len(segments)-1][key] = value
+	}
+	return segments
+}
+
+// formatSegments writes segments back the way writeSplat does.
+func formatSegments(segments []map[string]string) string {
+	var sb strings.Builder
+	sb.WriteString("segments:\n")
+	for _, segment := range segments {
+		prefix := "  - "
+		for _, key := range []string{"name", "type", "start"} {
+			if value, ok := segment[key]; ok {
+				fmt.Fprintf(&sb, "%s%s: %s\n", prefix, key, value)
+				prefix = "    "
+			}
+		}
+	}
+	return sb.String()
+}
+
+func TestWriteSplat(t *testing.T) {
+	got := writeTest(t, writeSplat, testReport())
+	golden(t, "splat.yaml", got)
+	segments := parseSegments(t, got)
+	if back := formatSegments(segments); back != got {
+		t.Errorf("segments do not round-trip, got back\n%s", back)
+	}
+	var names []string
+	prev := int64(-1)
+	for _, segment := range segments {
+		if segment["type"] != "c" {
+			t.Errorf("segment of type %q", segment["type"])
+		}
+		start, err := strconv.ParseInt(segment["start"], 0, 64)
+		if err != nil || start <= prev {
+			t.Errorf("start %q not after 0x%X", segment["start"], prev)
+		}
+		prev = start
+		names = append(names, segment["name"])
+	}
+	// the gaps after B and C get unnamed fillers
+	if want := []string{"a", "b", "", "c", "", "b"}; !slices.Equal(names, want) {
+		t.Errorf("segments %q, want %q", names, want)
+	}
+}
+
+func TestWriteIDC(t *testing.T) {
+	r := testReport()
+	got := writeTest(t, writeIDC, r)
+	if n := strings.Count(got, "set_name("); n != len(r.Matches)+len(r.Symbols) {
+		t.Errorf("%d set_name calls, want one per match and symbol, %d", n, len(r.Matches)+len(r.Symbols))
+	}
+	if n := strings.Count(got, "add_func("); n != len(r.Matches) {
+		t.Errorf("%d add_func calls, want one per match, %d", n, len(r.Matches))
+	}
+	if !strings.Contains(got, "\tadd_func(0x80010200, 0x80010220);\n") {
+		t.Errorf("no function over C.OBJ in\n%s", got)
+	}
+}
+
+func TestWriteGrouped(t *testing.T) {
+	got := writeTest(t, writeGrouped, testReport())
+	want := `LIBA
+  A.OBJ
+    [0x100, 0x140] PSY-Q 400
+      FuncA = 0x80010100
+  B.OBJ
+    [0x140, 0x180] PSY-Q 400
+      FuncB = 0x80010140
+      bssB = 0x80090000 // bss
+    [0x300, 0x340] PSY-Q 400
+      FuncB = 0x80010300
+LIBC
+  C.OBJ
+    [0x200, 0x220] PSY-Q 440
+      FuncC = 0x80010200
+      FuncC2 = 0x80010210
+`
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTextShowVersions(t *testing.T) {
+	r := testReport()
+	r.showVersions = true
+	got := writeTest(t, writeText, r)
+	for _, want := range []string{
+		" - [0x100, c, a] # PSY-Q 400\n",
+		" - [0x200, c, c] # PSY-Q 440\n",
+		" - [0x180, c]\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("no %q in\n%s", want, got)
+		}
+	}
+	r.showVersions = false
+	if got := writeTest(t, writeText, r); strings.Contains(got, "PSY-Q") {
+		t.Errorf("versions written without -show-versions:\n%s", got)
+	}
+}
+
+func TestWriteTextOnly(t *testing.T) {
+	r := testReport()
+	r.noSegments = true
+	want := "FuncA = 0x80010100\n" +
+		"FuncB = 0x80010140\n" +
+		"FuncC = 0x80010200\n" +
+		"FuncC2 = 0x80010210\n" +
+		"FuncB = 0x80010300\n" +
+		"bssB = 0x80090000 // bss\n"
+	if got := writeTest(t, writeText, r); got != want {
+		t.Errorf("symbols only: got\n%s\nwant\n%s", got, want)
+	}
+	r.noSegments, r.noSymbols = false, true
+	want = " - [0x100, c, a]\n" +
+		" - [0x140, c, b]\n" +
+		" - [0x180, c]\n" +
+		" - [0x200, c, c]\n" +
+		" - [0x220, c]\n" +
+		" - [0x300, c, b]\n"
+	if got := writeTest(t, writeText, r); got != want {
+		t.Errorf("segments only: got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestOnlyKeepsDiagnosticsOffStdout(t *testing.T) {
+	provider := psyq.StaticProvider{"400": {testPattern}}
+	for _, opts := range []options{
+		{format: "text", versions: []string{"400"}, symbolsOnly: true},
+		{format: "text", versions: []string{"400"}, segmentsOnly: true},
+		{format: "text", versions: []string{"400"}, symbolsOnly: true, coverageByObject: true},
+		{format: "text", versions: []string{"400"}, segmentsOnly: true, coverageByObject: true},
+	} {
+		var diag strings.Builder
+		opts.diag = &diag
+		var sb strings.Builder
+		if err := do(context.Background(), &sb, "", withCode(0x100, 0x10), 0x80010000, provider, opts); err != nil {
+			t.Fatal(err)
+		}
+		want := "Test = 0x80010010\n"
+		if opts.segmentsOnly {
+			want = " - [0x10, c, test]\n"
+		}
+		if sb.String() != want {
+			t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+		}
+		if opts.coverageByObject && !strings.Contains(diag.String(), "LIBRARY") {
+			t.Errorf("coverage table missing from the diagnostics:\n%s", diag.String())
+		}
+	}
+}
+
+func TestWriteDiff(t *testing.T) {
+	diff := psyq.Diff{
+		Added:          []psyq.Symbol{{Name: "New", Address: 0x80010060}},
+		Removed:        []psyq.Symbol{{Name: "Gone", Address: 0x80010040}},
+		Moved:          []psyq.SymbolMove{{Name: "Moved", From: 0x80010080, To: 0x800100C0}},
+		AddedObjects:   []psyq.Object{{Name: "NEW.OBJ", Address: 0x80010060}},
+		RemovedObjects: []psyq.Object{{Name: "GONE.OBJ", Address: 0x80010040}},
+		MovedObjects:   []psyq.ObjectMove{{Name: "MOVED.OBJ", From: 0x80010080, To: 0x800100C0}},
+	}
+	want := "- 80010040 GONE.OBJ\n" +
+		"+ 80010060 NEW.OBJ\n" +
+		"> 80010080 800100C0 MOVED.OBJ\n" +
+		"- 80010040 Gone\n" +
+		"+ 80010060 New\n" +
+		"> 80010080 800100C0 Moved\n"
+	var sb strings.Builder
+	if err := writeDiff(&sb, "", diff, "text"); err != nil {
+		t.Fatal(err)
+	}
+	if sb.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", sb.String(), want)
+	}
+}
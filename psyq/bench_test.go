@@ -0,0 +1,72 @@
+package psyq
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/xeeynamo/go-psyq-signatures/internal/corpus"
+)
+
+// benchCorpus builds the text section and signatures the benchmarks scan,
+// one object per pattern of corpus.Generate.
+func benchCorpus(size, count int) ([]byte, []Signature) {
+	b, patterns := corpus.Generate(1, size, count)
+	items := make([]Signature, 0, count)
+	for i, pattern := range patterns {
+		items = append(items, Signature{
+			Name:      fmt.Sprintf("BENCH%04d.OBJ", i),
+			Signature: pattern,
+			Labels:    []Labels{{Name: fmt.Sprintf("Bench%04d", i)}},
+		})
+	}
+	return b, items
+}
+
+func benchmarkScan(b *testing.B, concurrency int) {
+	data, items := benchCorpus(256<<10, 500)
+	db, err := LoadDatabase(b.Context(), StaticProvider{"bench": items}, []string{"bench"}, 1)
+	if err != nil {
+		b.Fatal(err)
+	}
+	opts := Options{Provider: db, Versions: []string{"bench"}, Concurrency: concurrency}
+	b.SetBytes(int64(len(data)))
+	for b.Loop() {
+		if _, err := Scan(data, DefaultBaseAddr, opts); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	benchmarkScan(b, DefaultConcurrency)
+}
+
+func BenchmarkScanSequential(b *testing.B) {
+	benchmarkScan(b, 1)
+}
+
+// BenchmarkMemoize scans several files in a row, parsing the signatures
+// for every file or once for all of them.
+func BenchmarkMemoize(b *testing.B) {
+	const files = 4
+	data, items := benchCorpus(64<<10, 500)
+	static := StaticProvider{"bench": items}
+	for _, bb := range []struct {
+		name     string
+		provider func() SignatureProvider
+	}{
+		{"parse-each", func() SignatureProvider { return static }},
+		{"memoized", func() SignatureProvider { return Memoize(static) }},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for b.Loop() {
+				opts := Options{Provider: bb.provider(), Versions: []string{"bench"}}
+				for range files {
+					if _, err := Scan(data, DefaultBaseAddr, opts); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package psyq
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// SignatureCache stores the signatures downloaded for each SDK version on
+// disk, so that repeated runs do not hit the GitHub API. Along with the
+// signatures it keeps the ETag of the listing they come from, so expired
+// entries can be revalidated instead of downloaded again. A nil cache is
+// valid and never hits.
+type SignatureCache struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewSignatureCache returns a cache in the user cache directory whose entries
+// expire ttl after they were stored.
+func NewSignatureCache(ttl time.Duration) (*SignatureCache, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &SignatureCache{
+		dir: filepath.Join(dir, "go-psyq-signatures"),
+		ttl: ttl,
+	}, nil
+}
+
+func (c *SignatureCache) path(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key)+".json")
+}
+
+func (c *SignatureCache) etagPath(key string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(key)+".etag")
+}
+
+// cacheEntry is what the cache holds for a key.
+type cacheEntry struct {
+	libraries map[string][]Signature // keyed by library file
+	etag      string                 // of the listing, empty when unknown
+	expired   bool                   // older than the TTL, to revalidate
+}
+
+// load returns the entry stored under key. Expired entries are returned
+// with expired set, missing or unreadable ones are reported as a miss.
+func (c *SignatureCache) load(key string) (cacheEntry, bool) {
+	if c == nil {
+		return cacheEntry{}, false
+	}
+	f, err := os.Open(c.path(key))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	var entry cacheEntry
+	if err := json.NewDecoder(f).Decode(&entry.libraries); err != nil {
+		return cacheEntry{}, false
+	}
+	entry.expired = time.Since(info.ModTime()) > c.ttl
+	if etag, err := os.ReadFile(c.etagPath(key)); err == nil {
+		entry.etag = string(etag)
+	}
+	return entry, true
+}
+
+// store writes the entry under key. The ETag is written last, so it never
+// describes signatures other than the stored ones.
+func (c *SignatureCache) store(key string, entry cacheEntry) error {
+	if c == nil {
+		return nil
+	}
+	data, err := json.Marshal(entry.libraries)
+	if err != nil {
+		return err
+	}
+	if err := c.write(key, c.path(key), data); err != nil {
+		return err
+	}
+	if entry.etag == "" {
+		if err := os.Remove(c.etagPath(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+		return nil
+	}
+	if err := c.write(key, c.etagPath(key), []byte(entry.etag)); err != nil {
+		return errors.Join(err, os.Remove(c.etagPath(key)))
+	}
+	return nil
+}
+
+// refresh marks the entry under key as fresh again, once GitHub confirmed
+// it did not change.
+func (c *SignatureCache) refresh(key string) error {
+	if c == nil {
+		return nil
+	}
+	now := time.Now()
+	return os.Chtimes(c.path(key), now, now)
+}
+
+// Clear deletes every entry of the cache, returning how many versions it
+// held. Clearing a cache that does not exist is not an error.
+func (c *SignatureCache) Clear() (int, error) {
+	if c == nil {
+		return 0, nil
+	}
+	n := 0
+	err := filepath.WalkDir(c.dir, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() && filepath.Ext(name) == ".json" {
+			n++
+		}
+		return nil
+	})
+	if errors.Is(err, fs.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return n, os.RemoveAll(c.dir)
+}
+
+// write replaces the file at name, which belongs to key, with data.
+func (c *SignatureCache) write(key, name string, data []byte) error {
+	dir := filepath.Dir(name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// write to a temporary file first so a concurrent or interrupted run
+	// never observes a partially written entry
+	tmp, err := os.CreateTemp(dir, path.Base(key)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
+	}
+	if err != nil {
+		return errors.Join(err, os.Remove(tmp.Name()))
+	}
+	return nil
+}
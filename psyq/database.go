@@ -0,0 +1,67 @@
+package psyq
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Database holds the parsed signatures of a set of SDK versions, all
+// fetched up front, so that scans against it do no I/O and share the
+// decoded patterns and skip tables. It is a SignatureProvider, safe for
+// concurrent use.
+type Database struct {
+	versions   []string
+	signatures map[string][]Signature
+}
+
+// LoadDatabase fetches the signatures of versions from provider, every one
+// of Versions when empty, with at most concurrency of them at once. The
+// first failure aborts the load.
+func LoadDatabase(ctx context.Context, provider SignatureProvider, versions []string, concurrency int) (*Database, error) {
+	if len(versions) == 0 {
+		versions = Versions
+	}
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	db := &Database{
+		versions:   slices.Clone(versions),
+		signatures: make(map[string][]Signature, len(versions)),
+	}
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	var mu sync.Mutex
+	for _, ver := range versions {
+		eg.Go(func() error {
+			signatures, err := provider.Signatures(ctx, ver)
+			if err != nil {
+				return fmt.Errorf("PSY-Q %s: %w", ver, err)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			db.signatures[ver] = signatures
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Versions lists the versions held, in the order they were requested.
+func (d *Database) Versions() []string {
+	return d.versions
+}
+
+func (d *Database) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	signatures, ok := d.signatures[version]
+	if !ok {
+		return nil, fmt.Errorf("PSY-Q %s was not loaded", version)
+	}
+	return signatures, nil
+}
@@ -0,0 +1,55 @@
+package psyq
+
+import (
+	"context"
+	"testing"
+)
+
+func TestDatabaseMissesDoNotAllocate(t *testing.T) {
+	// the first bytes of every object are in the data, so the misses are
+	// only told apart past the anchor
+	data := place(0x4000, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}, 0x10, 0x800, 0x3FF8)
+	items := []Signature{
+		{Name: "HIT.OBJ", Signature: "11 22 33 44 55 66 77 88", Labels: []Labels{{Name: "Hit"}}},
+		{Name: "LATE.OBJ", Signature: "11 22 33 44 55 66 77 99", Labels: []Labels{{Name: "Late"}}},
+		{Name: "WILD.OBJ", Signature: "11 22 ?? ?? 55 66 77 00", Labels: []Labels{{Name: "Wild"}}},
+		{Name: "LEAD.OBJ", Signature: "?? ?? ?? ?? 55 66 77 88 99", Labels: []Labels{{Name: "Lead"}}},
+		{Name: "LONG.OBJ", Signature: "11 22 33 44 55 66 77 88 11 22 33 44", Bss: []Labels{{Name: "longBss"}}},
+		{Name: "ABSENT.OBJ", Signature: "DE AD BE EF ?? ?? ?? ?? 01 02 03 04"},
+	}
+	db, err := LoadDatabase(context.Background(), StaticProvider{"t": items}, []string{"t"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := db.Signatures(context.Background(), "t")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var misses []Signature
+	for _, sig := range parsed {
+		if len(findAllMatches(data, sig)) == 0 {
+			misses = append(misses, sig)
+		}
+	}
+	if len(misses) != len(items)-1 {
+		t.Fatalf("%d signatures missing, want all but HIT.OBJ", len(misses))
+	}
+	// past loading, matching only allocates for the signatures that match
+	opts := Options{}.withDefaults().matchOptions()
+	allocs := testing.AllocsPerRun(10, func() {
+		getMatches(data, testBase, "t", misses, opts)
+	})
+	if allocs != 0 {
+		t.Errorf("%.1f allocations scanning %d missing signatures, want none", allocs, len(misses))
+	}
+}
+
+func TestDatabaseUnloadedVersion(t *testing.T) {
+	db, err := LoadDatabase(context.Background(), StaticProvider{"t": nil}, []string{"t"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Signatures(context.Background(), "400"); err == nil {
+		t.Error("signatures of a version never loaded, want an error")
+	}
+}
@@ -0,0 +1,132 @@
+package psyq
+
+import (
+	"cmp"
+	"slices"
+)
+
+// Diff tells how the objects and symbols of a scan changed since a
+// previous one, for instance after the signatures were updated.
+type Diff struct {
+	Added          []Symbol     `json:"added"`
+	Removed        []Symbol     `json:"removed"`
+	Moved          []SymbolMove `json:"moved"`
+	AddedObjects   []Object     `json:"added_objects"`
+	RemovedObjects []Object     `json:"removed_objects"`
+	MovedObjects   []ObjectMove `json:"moved_objects"`
+}
+
+// SymbolMove is a symbol found at a different address than before.
+type SymbolMove struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+	From uint32 `json:"from"`
+	To   uint32 `json:"to"`
+}
+
+// Object is a matched object, at the address its match starts.
+type Object struct {
+	Name    string `json:"name"`
+	Library string `json:"library"`
+	Address uint32 `json:"address"`
+}
+
+// ObjectMove is an object matched at a different address than before.
+type ObjectMove struct {
+	Name    string `json:"name"`
+	Library string `json:"library"`
+	From    uint32 `json:"from"`
+	To      uint32 `json:"to"`
+}
+
+// Empty tells whether nothing changed.
+func (d Diff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Moved) == 0 &&
+		len(d.AddedObjects) == 0 && len(d.RemovedObjects) == 0 && len(d.MovedObjects) == 0
+}
+
+// DiffResults compares the matches and symbols of current to the ones of
+// baseline, the matches by object name at the address they start. A name
+// found at several addresses only reports the addresses that are not in
+// both, paired in address order as moves, the rest being added or removed.
+// Every list is sorted by address.
+func DiffResults(baseline, current *Result) Diff {
+	diff := Diff{}
+	symbolName := func(s Symbol) string { return s.Name }
+	symbolAddr := func(s Symbol) uint32 { return s.Address }
+	added, removed, moved := diffByName(baseline.Symbols, current.Symbols, symbolName, symbolAddr)
+	diff.Added, diff.Removed = added, removed
+	diff.Moved = make([]SymbolMove, 0, len(moved))
+	for _, m := range moved {
+		diff.Moved = append(diff.Moved, SymbolMove{Name: m[1].Name, Kind: m[1].Kind, From: m[0].Address, To: m[1].Address})
+	}
+	objectName := func(o Object) string { return o.Name }
+	objectAddr := func(o Object) uint32 { return o.Address }
+	addedObjects, removedObjects, movedObjects := diffByName(objects(baseline), objects(current), objectName, objectAddr)
+	diff.AddedObjects, diff.RemovedObjects = addedObjects, removedObjects
+	diff.MovedObjects = make([]ObjectMove, 0, len(movedObjects))
+	for _, m := range movedObjects {
+		diff.MovedObjects = append(diff.MovedObjects, ObjectMove{Name: m[1].Name, Library: m[1].Library, From: m[0].Address, To: m[1].Address})
+	}
+	return diff
+}
+
+// objects returns the objects the matches of result found.
+func objects(result *Result) []Object {
+	out := make([]Object, 0, len(result.Matches))
+	for _, m := range result.Matches {
+		out = append(out, Object{Name: m.Name, Library: m.Library, Address: result.BaseAddr + uint32(m.Start)})
+	}
+	return out
+}
+
+// diffByName pairs the items of before and after by name, returning the
+// ones only after has, the ones only before has and the ones that moved,
+// as before and after pairs. The lists are sorted by address, the moves by
+// the address after, never nil.
+func diffByName[T any](before, after []T, name func(T) string, addr func(T) uint32) (added, removed []T, moved [][2]T) {
+	byName := func(items []T) map[string][]T {
+		out := map[string][]T{}
+		for _, item := range items {
+			out[name(item)] = append(out[name(item)], item)
+		}
+		for _, group := range out {
+			slices.SortFunc(group, func(a, b T) int { return cmp.Compare(addr(a), addr(b)) })
+		}
+		return out
+	}
+	// notIn returns the items of a at an address none of b is at
+	notIn := func(a, b []T) []T {
+		var out []T
+		for _, item := range a {
+			if !slices.ContainsFunc(b, func(other T) bool { return addr(other) == addr(item) }) {
+				out = append(out, item)
+			}
+		}
+		return out
+	}
+	old, now := byName(before), byName(after)
+	added, removed, moved = []T{}, []T{}, [][2]T{}
+	for key, was := range old {
+		is := now[key]
+		was, is = notIn(was, is), notIn(is, was)
+		n := min(len(was), len(is))
+		for i := range n {
+			moved = append(moved, [2]T{was[i], is[i]})
+		}
+		removed = append(removed, was[n:]...)
+		added = append(added, is[n:]...)
+	}
+	for key, is := range now {
+		if _, ok := old[key]; !ok {
+			added = append(added, is...)
+		}
+	}
+	byAddr := func(a, b T) int {
+		return cmp.Or(cmp.Compare(addr(a), addr(b)), cmp.Compare(name(a), name(b)))
+	}
+	slices.SortFunc(added, byAddr)
+	slices.SortFunc(removed, byAddr)
+	slices.SortFunc(moved, func(a, b [2]T) int { return byAddr(a[1], b[1]) })
+	return added, removed, moved
+}
@@ -0,0 +1,66 @@
+package psyq
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffResults(t *testing.T) {
+	baseline := &Result{
+		BaseAddr: testBase,
+		Matches: []Match{
+			{Name: "KEPT.OBJ", Library: "LIBA", Start: 0x10},
+			{Name: "GONE.OBJ", Library: "LIBA", Start: 0x40},
+			{Name: "MOVED.OBJ", Library: "LIBB", Start: 0x80},
+		},
+		Symbols: []Symbol{
+			{Name: "Kept", Address: testBase + 0x10, Kind: SymbolText},
+			{Name: "Gone", Address: testBase + 0x40, Kind: SymbolText},
+			{Name: "Moved", Address: testBase + 0x80, Kind: SymbolText},
+			{Name: "Twice", Address: testBase + 0x200, Kind: SymbolBss},
+		},
+	}
+	current := &Result{
+		BaseAddr: testBase,
+		Matches: []Match{
+			{Name: "KEPT.OBJ", Library: "LIBA", Start: 0x10},
+			{Name: "NEW.OBJ", Library: "LIBC", Start: 0x60},
+			{Name: "MOVED.OBJ", Library: "LIBB", Start: 0xC0},
+		},
+		Symbols: []Symbol{
+			{Name: "Kept", Address: testBase + 0x10, Kind: SymbolText},
+			{Name: "New", Address: testBase + 0x60, Kind: SymbolText},
+			{Name: "Moved", Address: testBase + 0xC0, Kind: SymbolText},
+			// the address both have still is not a move
+			{Name: "Twice", Address: testBase + 0x200, Kind: SymbolBss},
+			{Name: "Twice", Address: testBase + 0x300, Kind: SymbolBss},
+		},
+	}
+	want := Diff{
+		Added: []Symbol{
+			{Name: "New", Address: testBase + 0x60, Kind: SymbolText},
+			{Name: "Twice", Address: testBase + 0x300, Kind: SymbolBss},
+		},
+		Removed:        []Symbol{{Name: "Gone", Address: testBase + 0x40, Kind: SymbolText}},
+		Moved:          []SymbolMove{{Name: "Moved", Kind: SymbolText, From: testBase + 0x80, To: testBase + 0xC0}},
+		AddedObjects:   []Object{{Name: "NEW.OBJ", Library: "LIBC", Address: testBase + 0x60}},
+		RemovedObjects: []Object{{Name: "GONE.OBJ", Library: "LIBA", Address: testBase + 0x40}},
+		MovedObjects:   []ObjectMove{{Name: "MOVED.OBJ", Library: "LIBB", From: testBase + 0x80, To: testBase + 0xC0}},
+	}
+	if got := DiffResults(baseline, current); !reflect.DeepEqual(got, want) {
+		t.Errorf("diff\n%+v\nwant\n%+v", got, want)
+	}
+	if diff := DiffResults(current, current); !diff.Empty() {
+		t.Errorf("diff of a result with itself %+v, want empty", diff)
+	}
+}
+
+func TestDiffResultsObjectsOnly(t *testing.T) {
+	// an object without labels only shows in the matches
+	baseline := &Result{BaseAddr: testBase, Matches: []Match{{Name: "NOLABEL.OBJ", Start: 0x20}}}
+	current := &Result{BaseAddr: testBase}
+	diff := DiffResults(baseline, current)
+	if diff.Empty() || len(diff.RemovedObjects) != 1 || diff.RemovedObjects[0].Address != testBase+0x20 {
+		t.Errorf("diff %+v, want NOLABEL.OBJ removed", diff)
+	}
+}
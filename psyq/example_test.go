@@ -0,0 +1,37 @@
+package psyq_test
+
+import (
+	"fmt"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+func ExampleScan() {
+	// a text section holding an object at 0x10, and its signature with
+	// the relocated operand masked
+	text := make([]byte, 0x40)
+	copy(text[0x10:], []byte{0x21, 0x10, 0x80, 0x00, 0x0C, 0x00, 0x04, 0x08, 0x08, 0x00, 0xE0, 0x03})
+	provider := psyq.StaticProvider{
+		"400": {{
+			Name:      "ADD.OBJ",
+			Signature: "21 10 80 00 ?? ?? ?? ?? 08 00 E0 03",
+			Labels:    []psyq.Labels{{Name: "Add", Offset: 0}},
+		}},
+	}
+	result, err := psyq.Scan(text, psyq.DefaultBaseAddr, psyq.Options{
+		Provider: provider,
+		Versions: []string{"400"},
+	})
+	if err != nil {
+		panic(err)
+	}
+	for _, m := range result.Matches {
+		fmt.Printf("%s at 0x%X-0x%X\n", m.Name, m.Start, m.End)
+	}
+	for _, symbol := range result.Symbols {
+		fmt.Printf("%08X %s\n", symbol.Address, symbol.Name)
+	}
+	// Output:
+	// ADD.OBJ at 0x10-0x1C
+	// 80010010 Add
+}
@@ -0,0 +1,67 @@
+package psyq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+// ExeHeaderSize is the size of the PS-EXE header, the text section starting
+// right after it.
+const ExeHeaderSize = 0x800
+
+var exeMagic = []byte("PS-X EXE")
+
+// ExeHeader holds the fields of the PS-EXE header needed to load and scan
+// the executable.
+type ExeHeader struct {
+	PC0   uint32 // initial program counter
+	GP0   uint32 // initial global pointer
+	TAddr uint32 // address the text section is loaded at
+	TSize uint32 // size of the text section
+	DAddr uint32 // address of the data section
+	DSize uint32 // size of the data section
+	BAddr uint32 // address of the bss section, cleared at startup
+	BSize uint32 // size of the bss section
+	SAddr uint32 // initial stack pointer, or 0 for the default
+	SSize uint32 // offset added to SAddr
+}
+
+// DataRegions returns the data and bss sections the header declares, which
+// hold no code.
+func (h ExeHeader) DataRegions() []Region {
+	var regions []Region
+	if h.DSize != 0 {
+		regions = append(regions, Region{Addr: h.DAddr, Size: h.DSize})
+	}
+	if h.BSize != 0 {
+		regions = append(regions, Region{Addr: h.BAddr, Size: h.BSize})
+	}
+	return regions
+}
+
+// DefaultBaseAddr is where the text section is assumed to be loaded when
+// there is no header to tell.
+const DefaultBaseAddr = 0x80010000
+
+// ParseExeHeader decodes the header at the start of a PS-EXE.
+func ParseExeHeader(b []byte) (ExeHeader, error) {
+	if !bytes.HasPrefix(b, exeMagic) {
+		return ExeHeader{}, errors.New("not a PS-EXE: missing magic")
+	}
+	if len(b) <= ExeHeaderSize {
+		return ExeHeader{}, errors.New("not a PS-EXE: file too small")
+	}
+	return ExeHeader{
+		PC0:   binary.LittleEndian.Uint32(b[0x10:]),
+		GP0:   binary.LittleEndian.Uint32(b[0x14:]),
+		TAddr: binary.LittleEndian.Uint32(b[0x18:]),
+		TSize: binary.LittleEndian.Uint32(b[0x1C:]),
+		DAddr: binary.LittleEndian.Uint32(b[0x20:]),
+		DSize: binary.LittleEndian.Uint32(b[0x24:]),
+		BAddr: binary.LittleEndian.Uint32(b[0x28:]),
+		BSize: binary.LittleEndian.Uint32(b[0x2C:]),
+		SAddr: binary.LittleEndian.Uint32(b[0x30:]),
+		SSize: binary.LittleEndian.Uint32(b[0x34:]),
+	}, nil
+}
@@ -0,0 +1,308 @@
+package psyq
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// GitHubItem is an entry of a folder listed by the GitHub contents API.
+type GitHubItem struct {
+	Name        string `json:"name"`
+	Path        string `json:"path"`
+	Type        string `json:"type"` // "file" or "dir"
+	Size        int    `json:"size"`
+	DownloadURL string `json:"download_url"`
+}
+
+// githubClient performs the requests to GitHub, bounding how many of them
+// are in flight at once across the whole run and retrying the transient
+// failures.
+type githubClient struct {
+	token    string // GitHub token, never to be logged
+	sem      chan struct{}
+	attempts int           // maximum attempts per request
+	backoff  time.Duration // delay before the first retry, doubled after each
+	logger   *slog.Logger
+}
+
+func newGitHubClient(token string, concurrency, attempts int, logger *slog.Logger) *githubClient {
+	return &githubClient{
+		token:    token,
+		sem:      make(chan struct{}, concurrency),
+		attempts: attempts,
+		backoff:  time.Second,
+		logger:   logger,
+	}
+}
+
+// transientError is a failure worth retrying, after retryAfter if the
+// server asked for a specific delay.
+type transientError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *transientError) Error() string { return e.err.Error() }
+func (e *transientError) Unwrap() error { return e.err }
+
+// maxBackoff caps the exponential backoff, not the delays asked by GitHub.
+const maxBackoff = 30 * time.Second
+
+// get performs an authenticated GET when a token is available and turns any
+// non-200 response into an error. Rate limits, server errors and network
+// failures are retried with an exponential backoff honoring Retry-After.
+// When etag is not empty it is sent as If-None-Match, and a 304 response is
+// returned as a success. The caller must call release once done with the
+// response body.
+func (c *githubClient) get(ctx context.Context, url, etag string) (*http.Response, error) {
+	delay := c.backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := c.try(ctx, url, etag)
+		var terr *transientError
+		if err == nil || !errors.As(err, &terr) || attempt >= c.attempts {
+			return resp, err
+		}
+		wait := min(delay, maxBackoff)
+		if terr.retryAfter > 0 {
+			wait = terr.retryAfter
+		}
+		c.logger.Debug("retrying GitHub request", "url", url, "attempt", attempt, "delay", wait, "err", err)
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// try performs a single attempt of get.
+func (c *githubClient) try(ctx context.Context, url, etag string) (*http.Response, error) {
+	select {
+	case c.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		<-c.sem
+		return nil, err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	// asked explicitly, the transport leaves the decompression to us
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		<-c.sem
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, &transientError{err: err}
+	}
+	if resp.Header.Get("Content-Encoding") == "gzip" && resp.StatusCode != http.StatusNotModified {
+		zr, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			<-c.sem
+			resp.Body.Close()
+			return nil, &transientError{err: fmt.Errorf("corrupted gzip response: %w", err)}
+		}
+		resp.Body = gzipBody{zr, resp.Body}
+	}
+	if resp.StatusCode != http.StatusOK && (etag == "" || resp.StatusCode != http.StatusNotModified) {
+		defer func() { <-c.sem }()
+		defer resp.Body.Close()
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if isRateLimited(resp) {
+			err := errors.New("GitHub API rate limit exceeded for the provided token")
+			if c.token == "" {
+				err = errors.New("GitHub API rate limit exceeded, set GITHUB_TOKEN or -token to raise it")
+			}
+			return nil, &transientError{err: err, retryAfter: retryAfter}
+		}
+		err := fmt.Errorf("GitHub API returned %s", resp.Status)
+		if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			return nil, &transientError{err: err, retryAfter: retryAfter}
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// gzipBody decompresses a response body, closing both once done.
+type gzipBody struct {
+	*gzip.Reader
+	body io.ReadCloser
+}
+
+func (b gzipBody) Close() error {
+	return errors.Join(b.Reader.Close(), b.body.Close())
+}
+
+// parseRetryAfter decodes a Retry-After header, either in seconds or as an
+// HTTP date, returning 0 when absent or invalid.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(value); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return max(time.Until(t), 0)
+	}
+	return 0
+}
+
+// release closes the body of a response returned by get and frees its slot.
+func (c *githubClient) release(resp *http.Response) {
+	resp.Body.Close()
+	<-c.sem
+}
+
+func isRateLimited(resp *http.Response) bool {
+	if resp.StatusCode != http.StatusForbidden && resp.StatusCode != http.StatusTooManyRequests {
+		return false
+	}
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		return true
+	}
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	return strings.Contains(strings.ToLower(string(body)), "rate limit")
+}
+
+// githubRepo identifies the repository and ref signatures are fetched from.
+type githubRepo struct {
+	owner string
+	name  string
+	ref   string // branch, the default branch when empty
+}
+
+// cacheKey returns the key of a version in the signature cache. Entries of
+// the default repository are kept at the top of the cache, as they always
+// were, and any other one in a directory of its own.
+func (r githubRepo) cacheKey(sdkver string) string {
+	if r.owner == DefaultOwner && r.name == DefaultRepo && r.ref == "" {
+		return sdkver
+	}
+	ref := r.ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return path.Join(cacheKeyPart(r.owner), cacheKeyPart(r.name), cacheKeyPart(ref), sdkver)
+}
+
+// cacheKeyPart escapes s into a single directory name, so that a ref such
+// as feature/x or ../.. stays within the directory of its repository.
+func cacheKeyPart(s string) string {
+	s = url.PathEscape(s)
+	if s == "." || s == ".." {
+		return strings.ReplaceAll(s, ".", "%2E")
+	}
+	return s
+}
+
+// errNotModified reports a folder listing still matching the ETag it was
+// requested with.
+var errNotModified = errors.New("not modified")
+
+// fetchGitHubFolder lists a folder of the repository, along with the ETag of
+// the listing. Unless etag is empty, errNotModified is returned when the
+// listing still has that ETag.
+func fetchGitHubFolder(ctx context.Context, client *githubClient, repo githubRepo, folder, etag string) ([]GitHubItem, string, error) {
+	u := fmt.Sprintf("https://api.github.com/repos/%s/%s/contents/%s", repo.owner, repo.name, url.PathEscape(folder))
+	if repo.ref != "" {
+		u += "?ref=" + url.QueryEscape(repo.ref)
+	}
+	resp, err := client.get(ctx, u, etag)
+	if err != nil {
+		return nil, "", err
+	}
+	defer client.release(resp)
+	if resp.StatusCode == http.StatusNotModified {
+		return nil, etag, errNotModified
+	}
+	var items []GitHubItem
+	if err := json.NewDecoder(resp.Body).Decode(&items); err != nil {
+		return nil, "", err
+	}
+	return items, resp.Header.Get("ETag"), nil
+}
+
+// downloadPsyqSignatures fetches the signatures of an SDK version from
+// GitHub, keyed by the library file they were found in, and the ETag of the
+// version folder. Unless etag is empty, errNotModified is returned without
+// downloading anything when the folder still has that ETag.
+func downloadPsyqSignatures(ctx context.Context, client *githubClient, repo githubRepo, sdkver, etag string) (map[string][]Signature, string, error) {
+	files, etag, err := fetchGitHubFolder(ctx, client, repo, sdkver, etag)
+	if err != nil {
+		return nil, "", err
+	}
+	libraries := map[string][]Signature{}
+	var mu sync.Mutex
+	eg, ctx := errgroup.WithContext(ctx)
+	for _, file := range files {
+		eg.Go(func() error {
+			resp, err := client.get(ctx, file.DownloadURL, "")
+			if err != nil {
+				return err
+			}
+			defer client.release(resp)
+			name, items, err := decodeLibrary(file.Name, resp.Body)
+			if err != nil {
+				return err
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			libraries[name] = append(libraries[name], items...)
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, "", err
+	}
+	return libraries, etag, nil
+}
+
+// fetchPsyqSignatures returns the signatures of an SDK version from the
+// cache, revalidating expired entries with their ETag, or downloads them.
+func fetchPsyqSignatures(ctx context.Context, client *githubClient, repo githubRepo, sdkver string, cache *SignatureCache, skipInvalid bool) ([]Signature, error) {
+	key := repo.cacheKey(sdkver)
+	entry, ok := cache.load(key)
+	if !ok || entry.expired {
+		libraries, etag, err := downloadPsyqSignatures(ctx, client, repo, sdkver, entry.etag)
+		switch {
+		case errors.Is(err, errNotModified):
+			client.logger.Debug("cached signatures still up to date", "version", sdkver)
+			if err := cache.refresh(key); err != nil {
+				client.logger.Warn("unable to refresh cached signatures", "version", sdkver, "err", err)
+			}
+		case err != nil:
+			return nil, err
+		default:
+			entry = cacheEntry{libraries: libraries, etag: etag}
+			if err := cache.store(key, entry); err != nil {
+				client.logger.Warn("unable to cache signatures", "version", sdkver, "err", err)
+			}
+		}
+	}
+	return parseLibraries(entry.libraries, sdkver, skipInvalid, client.logger)
+}
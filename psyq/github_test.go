@@ -0,0 +1,301 @@
+package psyq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeGitHub sends the requests to GitHub to handler for the duration of
+// the test.
+func fakeGitHub(t *testing.T, handler http.Handler) {
+	t.Helper()
+	srv := httptest.NewServer(handler)
+	t.Cleanup(srv.Close)
+	target, err := url.Parse(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prev := http.DefaultClient.Transport
+	http.DefaultClient.Transport = redirectTransport{target}
+	t.Cleanup(func() { http.DefaultClient.Transport = prev })
+}
+
+// redirectTransport sends every request to target, whatever its host.
+type redirectTransport struct {
+	target *url.URL
+}
+
+func (rt redirectTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host, req.Host = rt.target.Scheme, rt.target.Host, ""
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// testRepo is the repository served by a fakeRepo.
+var testRepo = githubRepo{owner: "owner", name: "repo"}
+
+// fakeRepo serves the contents listing of testRepo and the files it lists,
+// keyed by path as "400/LIBTEST.json". It counts the requests by path.
+// When etag is set, the listings carry it and revalidate against it.
+type fakeRepo struct {
+	files map[string]string
+	etag  string
+	mu    sync.Mutex
+	hits  map[string]int
+}
+
+func newFakeRepo(files map[string]string) *fakeRepo {
+	return &fakeRepo{files: files, hits: map[string]int{}}
+}
+
+func (f *fakeRepo) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	f.hits[r.URL.Path]++
+	f.mu.Unlock()
+	if folder, ok := strings.CutPrefix(r.URL.Path, "/repos/owner/repo/contents/"); ok {
+		if f.etag != "" {
+			w.Header().Set("ETag", f.etag)
+			if r.Header.Get("If-None-Match") == f.etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		}
+		var items []GitHubItem
+		for name := range f.files {
+			if file, ok := strings.CutPrefix(name, folder+"/"); ok {
+				items = append(items, GitHubItem{
+					Name:        file,
+					Path:        name,
+					Type:        "file",
+					DownloadURL: "https://raw.example.com/" + name,
+				})
+			}
+		}
+		if len(items) == 0 {
+			http.NotFound(w, r)
+			return
+		}
+		sort.Slice(items, func(i, j int) bool { return items[i].Name < items[j].Name })
+		json.NewEncoder(w).Encode(items)
+		return
+	}
+	content, ok := f.files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	w.Write([]byte(content))
+}
+
+func (f *fakeRepo) hitsOf(path string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.hits[path]
+}
+
+func testClient(concurrency, attempts int) *githubClient {
+	c := newGitHubClient("", concurrency, attempts, slog.New(slog.DiscardHandler))
+	c.backoff = time.Millisecond
+	return c
+}
+
+func TestGitHubConcurrency(t *testing.T) {
+	files := map[string]string{}
+	for _, name := range []string{"A", "B", "C", "D", "E", "F", "G", "H", "I", "J"} {
+		files["400/LIB"+name+".json"] = `[{"name":"` + name + `.OBJ","sig":"01 02 03 04"}]`
+	}
+	repo := newFakeRepo(files)
+	var inFlight, peak atomic.Int32
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		repo.ServeHTTP(w, r)
+	}))
+	const concurrency = 3
+	signatures, err := fetchPsyqSignatures(context.Background(), testClient(concurrency, 1), testRepo, "400", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != len(files) {
+		t.Errorf("%d signatures, want %d", len(signatures), len(files))
+	}
+	if p := peak.Load(); p > concurrency {
+		t.Errorf("%d requests in flight at once, want at most %d", p, concurrency)
+	}
+}
+
+func TestGitHubFetchesEveryFileOnce(t *testing.T) {
+	files := map[string]string{}
+	for i := range 20 {
+		files[fmt.Sprintf("400/LIB%02d.json", i)] = fmt.Sprintf(`[{"name":"OBJ%02d.OBJ","sig":"01 02 03 04"}]`, i)
+	}
+	repo := newFakeRepo(files)
+	fakeGitHub(t, repo)
+	signatures, err := fetchPsyqSignatures(context.Background(), testClient(8, 1), testRepo, "400", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects := map[string]bool{}
+	for _, sig := range signatures {
+		objects[sig.Name] = true
+	}
+	if len(objects) != len(files) {
+		t.Errorf("%d distinct objects, want one per file, %d", len(objects), len(files))
+	}
+	for path := range files {
+		if hits := repo.hitsOf("/" + path); hits != 1 {
+			t.Errorf("%s fetched %d times, want once", path, hits)
+		}
+	}
+}
+
+func TestGitHubCacheKeyStaysInRepo(t *testing.T) {
+	keys := map[string]string{}
+	for _, ref := range []string{"", "main", "feature/x", "feature%2Fx", "..", "../../..", `..\..\x`, "a/../../b"} {
+		repo := testRepo
+		repo.ref = ref
+		key := repo.cacheKey("400")
+		parts := strings.Split(key, "/")
+		if !filepath.IsLocal(filepath.FromSlash(key)) || len(parts) != 4 || parts[0] != "owner" || parts[1] != "repo" ||
+			parts[2] == "." || parts[2] == ".." || strings.ContainsAny(parts[2], `\`) {
+			t.Errorf("ref %q cached under %q, want a directory of owner/repo", ref, key)
+		}
+		if other, ok := keys[key]; ok {
+			t.Errorf("refs %q and %q share the key %q", ref, other, key)
+		}
+		keys[key] = ref
+	}
+}
+
+func TestGitHubRetry(t *testing.T) {
+	for _, tt := range []struct {
+		attempts int
+		wantErr  bool
+	}{
+		{attempts: 3},
+		{attempts: 2, wantErr: true},
+	} {
+		repo := newFakeRepo(map[string]string{"400/LIBA.json": `[{"name":"A.OBJ","sig":"01 02"}]`})
+		var failures atomic.Int32
+		fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if failures.Add(1) <= 2 {
+				http.Error(w, "slow down", http.StatusTooManyRequests)
+				return
+			}
+			repo.ServeHTTP(w, r)
+		}))
+		_, err := fetchPsyqSignatures(context.Background(), testClient(1, tt.attempts), testRepo, "400", nil, false)
+		if (err != nil) != tt.wantErr {
+			t.Fatalf("%d attempts: err = %v, want an error %v", tt.attempts, err, tt.wantErr)
+		}
+		if got := repo.hitsOf("/repos/owner/repo/contents/400"); !tt.wantErr && got != 1 {
+			t.Errorf("listing served %d times after the 429s, want 1", got)
+		}
+	}
+}
+
+func TestGitHubRevalidate(t *testing.T) {
+	const listing, file = "/repos/owner/repo/contents/400", "/400/LIBA.json"
+	repo := newFakeRepo(map[string]string{"400/LIBA.json": `[{"name":"A.OBJ","sig":"01 02"}]`})
+	repo.etag = `"v1"`
+	fakeGitHub(t, repo)
+	// every entry is expired as soon as it is stored
+	cache := &SignatureCache{dir: t.TempDir(), ttl: 0}
+	client := testClient(1, 1)
+	fetch := func() {
+		t.Helper()
+		signatures, err := fetchPsyqSignatures(context.Background(), client, testRepo, "400", cache, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signatures) != 1 || signatures[0].Name != "A.OBJ" {
+			t.Fatalf("signatures %+v, want A.OBJ", signatures)
+		}
+	}
+
+	fetch()
+	if repo.hitsOf(listing) != 1 || repo.hitsOf(file) != 1 {
+		t.Fatalf("first fetch: %v", repo.hits)
+	}
+	fetch()
+	if repo.hitsOf(listing) != 2 || repo.hitsOf(file) != 1 {
+		t.Errorf("revalidated with a 304, the files must not be downloaded again: %v", repo.hits)
+	}
+	repo.etag = `"v2"`
+	fetch()
+	if repo.hitsOf(listing) != 3 || repo.hitsOf(file) != 2 {
+		t.Errorf("changed listing, the files must be downloaded again: %v", repo.hits)
+	}
+}
+
+// gzipped returns s compressed with gzip.
+func gzipped(t *testing.T, s string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write([]byte(s)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestGitHubGzip(t *testing.T) {
+	repo := newFakeRepo(map[string]string{
+		"400/LIBA.json": `[{"name":"A.OBJ","sig":"01 02"}]`,
+		// compressed on disk, served as is
+		"400/LIBB.json.gz": string(gzipped(t, `[{"name":"B.OBJ","sig":"03 04"}]`)),
+	})
+	var compressed atomic.Int32
+	fakeGitHub(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			repo.ServeHTTP(w, r)
+			return
+		}
+		// compress the whole response, as GitHub does
+		rec := httptest.NewRecorder()
+		repo.ServeHTTP(rec, r)
+		w.Header().Set("Content-Encoding", "gzip")
+		w.WriteHeader(rec.Code)
+		w.Write(gzipped(t, rec.Body.String()))
+		compressed.Add(1)
+	}))
+	signatures, err := fetchPsyqSignatures(context.Background(), testClient(2, 1), testRepo, "400", nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if compressed.Load() == 0 {
+		t.Fatal("gzip never asked for")
+	}
+	var got []string
+	for _, sig := range signatures {
+		got = append(got, sig.Library+"/"+sig.Name)
+	}
+	if want := []string{"LIBA/A.OBJ", "LIBB/B.OBJ"}; !slices.Equal(got, want) {
+		t.Errorf("signatures %v, want %v", got, want)
+	}
+}
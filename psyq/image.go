@@ -0,0 +1,72 @@
+package psyq
+
+import (
+	"bytes"
+	"fmt"
+)
+
+const (
+	// sectorSize is the user data held by every sector of the disc.
+	sectorSize = 2048
+	// rawSectorSize is the size of the sectors of raw images, such as
+	// .bin, which keep the sync pattern, headers and error correction.
+	rawSectorSize = 2352
+	// rawDataOffset is where the user data of a Mode 2 Form 1 sector starts.
+	rawDataOffset = 24
+)
+
+// ImageExe is an executable found in a disc image.
+type ImageExe struct {
+	Offset int // of the header in the image
+	Header ExeHeader
+	raw    bool
+}
+
+// FindExes searches a disc image for PS-EXE headers, returning them in the
+// order they appear. Both raw images of 2352 bytes sectors and images of
+// plain 2048 bytes sectors, such as .iso, are supported. Files start on a
+// sector boundary, so the magic found anywhere else is ignored.
+func FindExes(image []byte) []ImageExe {
+	var exes []ImageExe
+	for i := 0; ; i++ {
+		n := bytes.Index(image[i:], exeMagic)
+		if n < 0 {
+			return exes
+		}
+		i += n
+		exe := ImageExe{Offset: i}
+		switch {
+		case i%rawSectorSize == rawDataOffset:
+			exe.raw = true
+		case i%sectorSize != 0:
+			continue
+		}
+		header, err := ParseExeHeader(image[i:min(i+ExeHeaderSize+1, len(image))])
+		if err != nil || header.TSize == 0 {
+			continue
+		}
+		exe.Header = header
+		exes = append(exes, exe)
+	}
+}
+
+// Extract returns the executable as a contiguous PS-EXE, header included,
+// leaving out the sector headers and error correction of raw images.
+func (e ImageExe) Extract(image []byte) ([]byte, error) {
+	size := ExeHeaderSize + int(e.Header.TSize)
+	if !e.raw {
+		if e.Offset+size > len(image) {
+			return nil, fmt.Errorf("executable at 0x%X is truncated", e.Offset)
+		}
+		return image[e.Offset : e.Offset+size], nil
+	}
+	exe := make([]byte, 0, size)
+	for sector := e.Offset - rawDataOffset; len(exe) < size; sector += rawSectorSize {
+		if sector+rawDataOffset+sectorSize > len(image) {
+			return nil, fmt.Errorf("executable at 0x%X is truncated", e.Offset)
+		}
+		data := image[sector+rawDataOffset : sector+rawDataOffset+sectorSize]
+		exe = append(exe, data[:min(len(data), size-len(exe))]...)
+	}
+	return exe, nil
+}
@@ -0,0 +1,87 @@
+package psyq
+
+import (
+	"bytes"
+	"encoding/binary"
+	"slices"
+	"testing"
+)
+
+// testImageExe returns a PS-EXE of tSize bytes of text, filled with seed,
+// loaded at DefaultBaseAddr.
+func testImageExe(tSize int, seed byte) []byte {
+	exe := make([]byte, ExeHeaderSize+tSize)
+	copy(exe, exeMagic)
+	binary.LittleEndian.PutUint32(exe[0x18:], DefaultBaseAddr)
+	binary.LittleEndian.PutUint32(exe[0x1C:], uint32(tSize))
+	for i := ExeHeaderSize; i < len(exe); i++ {
+		exe[i] = seed + byte(i)
+	}
+	return exe
+}
+
+// rawImage lays data out over the user data of 2352 bytes sectors, with
+// junk in the sync pattern, headers and error correction around it.
+func rawImage(data []byte) []byte {
+	sectors := (len(data) + sectorSize - 1) / sectorSize
+	image := bytes.Repeat([]byte{0xAA}, sectors*rawSectorSize)
+	for i := range sectors {
+		chunk := data[i*sectorSize : min((i+1)*sectorSize, len(data))]
+		copy(image[i*rawSectorSize+rawDataOffset:], chunk)
+	}
+	return image
+}
+
+func TestFindExes(t *testing.T) {
+	first, second := testImageExe(0x1000, 1), testImageExe(0x880, 2)
+	iso := make([]byte, 16*sectorSize)
+	// a header mid-sector is file content, not the start of a file
+	copy(iso[0x100:], testImageExe(0x10, 3))
+	copy(iso[3*sectorSize:], first)
+	copy(iso[9*sectorSize:], second)
+	tests := []struct {
+		name    string
+		image   []byte
+		offsets []int
+	}{
+		{"iso", iso, []int{3 * sectorSize, 9 * sectorSize}},
+		{"bin", rawImage(iso), []int{3*rawSectorSize + rawDataOffset, 9*rawSectorSize + rawDataOffset}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exes := FindExes(tt.image)
+			var offsets []int
+			for _, exe := range exes {
+				offsets = append(offsets, exe.Offset)
+			}
+			if !slices.Equal(offsets, tt.offsets) {
+				t.Fatalf("executables at %X, want %X", offsets, tt.offsets)
+			}
+			for i, want := range [][]byte{first, second} {
+				got, err := exes[i].Extract(tt.image)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(got, want) {
+					t.Errorf("executable %d differs from the one laid out", i)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractTruncated(t *testing.T) {
+	exe := testImageExe(0x1000, 1)
+	for name, image := range map[string][]byte{
+		"iso": exe[:len(exe)-1],
+		"bin": rawImage(exe)[:2*rawSectorSize],
+	} {
+		exes := FindExes(image)
+		if len(exes) != 1 {
+			t.Fatalf("%s: %d executables, want 1", name, len(exes))
+		}
+		if _, err := exes[0].Extract(image); err == nil {
+			t.Errorf("%s: extracted a truncated executable, want an error", name)
+		}
+	}
+}
@@ -0,0 +1,349 @@
+package psyq
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// matchAt tells if the signature matches at the beginning of b, which must
+// be at least as long as the signature.
+func matchAt(b []byte, signature Signature) bool {
+	for j, c := range signature.signature {
+		if !signature.wildcard[j] && b[j] != c {
+			return false
+		}
+	}
+	return true
+}
+
+// nextMatch returns the first offset at or after from where the signature
+// matches, or -1 if it does not match anywhere.
+func nextMatch(b []byte, signature Signature, from int) int {
+	sigLen := len(signature.signature)
+	if sigLen == 0 || sigLen > len(b) {
+		return -1
+	}
+	if signature.skip == nil {
+		return nextMatchNaive(b, signature, from)
+	}
+	anchor := signature.signature[signature.anchor : signature.anchor+signature.anchorLen]
+	tail := anchor[len(anchor)-1]
+	for i := from; i <= len(b)-sigLen; {
+		window := b[i+signature.anchor:]
+		c := window[len(anchor)-1]
+		if c == tail && bytes.Equal(window[:len(anchor)], anchor) && matchAt(b[i:], signature) {
+			return i
+		}
+		i += signature.skip[c]
+	}
+	return -1
+}
+
+// nextMatchNaive is the reference implementation of nextMatch, trying every
+// offset in turn.
+func nextMatchNaive(b []byte, signature Signature, from int) int {
+	sigLen := len(signature.signature)
+	if sigLen == 0 || sigLen > len(b) {
+		return -1
+	}
+	for i := from; i <= len(b)-sigLen; i++ {
+		if matchAt(b[i:], signature) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findAllMatches returns every offset where the signature matches.
+func findAllMatches(b []byte, signature Signature) []int {
+	var offsets []int
+	for i := nextMatch(b, signature, 0); i >= 0; i = nextMatch(b, signature, i+1) {
+		offsets = append(offsets, i)
+	}
+	return offsets
+}
+
+// Match is an object found in the scanned data. Start and End are offsets
+// from the beginning of the scanned data, End exclusive. End is where the
+// signature ends, trailing wildcards included, unless Options.SnapEnds
+// extends it over the padding up to the next match. Strength is the number
+// of non-wildcard bytes that matched, the higher the less likely the match
+// is spurious. Symbols and Bss map absolute addresses to the labels defined
+// by the object. Relocs is only filled when Options.ResolveRelocs is set.
+// Ambiguous tells the object was also found at other offsets.
+type Match struct {
+	Name      string            `json:"name"`
+	Library   string            `json:"library"`
+	Version   string            `json:"version"`
+	Start     int               `json:"start"`
+	End       int               `json:"end"`
+	Strength  int               `json:"strength"`
+	Ambiguous bool              `json:"ambiguous,omitempty"`
+	Relocs    []ResolvedReloc   `json:"relocs,omitempty"`
+	Symbols   map[uint32]string `json:"-"`
+	Bss       map[uint32]string `json:"-"`
+}
+
+// ResolvedReloc is the word found in the scanned data where a signature
+// has a relocation. Target is the destination of a j or jal instruction,
+// zero for any other word.
+type ResolvedReloc struct {
+	Address uint32 `json:"address"`
+	Value   uint32 `json:"value"`
+	Target  uint32 `json:"target,omitempty"`
+}
+
+// resolveRelocs decodes the words of b covered by the relocations of a
+// signature matching at offset. Each relocation is widened to the aligned
+// words holding it, so a partially masked instruction is read whole.
+func resolveRelocs(b []byte, baseAddr uint32, offset int, sig Signature) []ResolvedReloc {
+	var out []ResolvedReloc
+	next := 0
+	for _, r := range sig.relocs {
+		for i := max(r.Offset&^3, next); i < r.Offset+r.Size && i+4 <= len(sig.signature); i += 4 {
+			addr := baseAddr + uint32(offset+i)
+			value := binary.LittleEndian.Uint32(b[offset+i:])
+			reloc := ResolvedReloc{Address: addr, Value: value}
+			if op := value >> 26; op == 2 || op == 3 {
+				reloc.Target = (addr+4)&0xF0000000 | (value&0x03FFFFFF)<<2
+			}
+			out = append(out, reloc)
+			next = i + 4
+		}
+	}
+	return out
+}
+
+// matchKey identifies an occurrence of an object, so the same object found
+// at different offsets is reported once per offset.
+type matchKey struct {
+	name  string
+	start int
+}
+
+// DefaultExcludePrefixes are the prefixes of the internal labels generated
+// by the signature tooling rather than SDK symbols, which are not reported
+// unless Options.ExcludePrefixes says otherwise.
+var DefaultExcludePrefixes = []string{"loc_", "text_"}
+
+// labelFilter selects the labels reported as symbols by their prefix.
+type labelFilter struct {
+	include []string // when not empty, only these prefixes are reported
+	exclude []string
+}
+
+// keep tells if a label is reported. The longest prefix matching the name
+// decides, an include one winning a tie, so that including "loc_" brings
+// back the labels excluded by default while excluding "LibFoo" still drops
+// the labels of an included "Lib".
+func (f labelFilter) keep(name string) bool {
+	include, exclude := -1, -1
+	for _, prefix := range f.include {
+		if strings.HasPrefix(name, prefix) {
+			include = max(include, len(prefix))
+		}
+	}
+	for _, prefix := range f.exclude {
+		if strings.HasPrefix(name, prefix) {
+			exclude = max(exclude, len(prefix))
+		}
+	}
+	if exclude > include {
+		return false
+	}
+	return include >= 0 || len(f.include) == 0
+}
+
+// matchOptions tunes how matches are built from the signatures.
+type matchOptions struct {
+	relocs bool // resolve the relocations
+	labels labelFilter
+}
+
+func getMatches(b []byte, baseAddr uint32, sdkver string, signatures []Signature, opts matchOptions) []Match {
+	var matches []Match
+	for _, sig := range signatures {
+		for _, offset := range findAllMatches(b, sig) {
+			m := Match{
+				Start:    offset,
+				End:      offset + len(sig.signature),
+				Name:     NormalizeObjectName(sig.Name),
+				Library:  sig.Library,
+				Version:  sdkver,
+				Strength: sig.concrete,
+				Symbols:  map[uint32]string{},
+				Bss:      map[uint32]string{},
+			}
+			if opts.relocs {
+				m.Relocs = resolveRelocs(b, baseAddr, offset, sig)
+			}
+			for _, label := range sig.Labels {
+				if !opts.labels.keep(label.Name) {
+					continue
+				}
+				m.Symbols[baseAddr+uint32(offset)+label.Offset] = label.Name
+			}
+			for _, label := range sig.Bss {
+				if !opts.labels.keep(label.Name) {
+					continue
+				}
+				m.Bss[baseAddr+uint32(offset)+label.Offset] = label.Name
+			}
+			matches = append(matches, m)
+		}
+	}
+	return matches
+}
+
+// checkEvery is how many signatures getMatchesParallel matches between two
+// checks of its context.
+const checkEvery = 64
+
+// getMatchesParallel is like getMatches, splitting the signatures in
+// chunks matched concurrently. Each chunk holds a slot of sem while it is
+// matched, which bounds the goroutines matching across every version. The
+// matches come in the same order as from getMatches. Once ctx is done the
+// chunks stop and only its error is returned, never partial matches.
+func getMatchesParallel(ctx context.Context, b []byte, baseAddr uint32, sdkver string, signatures []Signature, opts matchOptions, sem chan struct{}) ([]Match, error) {
+	chunks := max(min(cap(sem), len(signatures)), 1)
+	size := (len(signatures) + chunks - 1) / chunks
+	results := make([][]Match, chunks)
+	var wg sync.WaitGroup
+	for i := range results {
+		chunk := signatures[min(i*size, len(signatures)):min((i+1)*size, len(signatures))]
+		wg.Go(func() {
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			for len(chunk) > 0 && ctx.Err() == nil {
+				n := min(checkEvery, len(chunk))
+				results[i] = append(results[i], getMatches(b, baseAddr, sdkver, chunk[:n], opts)...)
+				chunk = chunk[n:]
+			}
+		})
+	}
+	wg.Wait()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return slices.Concat(results...), nil
+}
+
+func getMatchesSorted(matches map[matchKey]Match) []Match {
+	out := make([]Match, 0, len(matches))
+	for _, m := range matches {
+		out = append(out, m)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Start != out[j].Start {
+			return out[i].Start < out[j].Start
+		}
+		if out[i].End != out[j].End {
+			return out[i].End > out[j].End
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// getSymbolsSorted returns the labels of every match, and the targets of
+// their resolved relocations that no label names.
+func getSymbolsSorted(matches map[matchKey]Match) []Symbol {
+	var out []Symbol
+	named := map[uint32]bool{}
+	for _, m := range matches {
+		for addr := range m.Symbols {
+			named[addr] = true
+		}
+	}
+	for _, m := range matches {
+		for _, reloc := range m.Relocs {
+			if reloc.Target == 0 || named[reloc.Target] {
+				continue
+			}
+			named[reloc.Target] = true
+			out = append(out, Symbol{
+				Name:    fmt.Sprintf("func_%08X", reloc.Target),
+				Address: reloc.Target,
+				Kind:    SymbolReloc,
+			})
+		}
+		for addr, name := range m.Symbols {
+			out = append(out, Symbol{
+				Name:    name,
+				Address: addr,
+				Kind:    SymbolText,
+			})
+		}
+		for addr, name := range m.Bss {
+			out = append(out, Symbol{
+				Name:    name,
+				Address: addr,
+				Kind:    SymbolBss,
+			})
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Address != out[j].Address {
+			return out[i].Address < out[j].Address
+		}
+		if out[i].Kind != out[j].Kind {
+			return out[i].Kind < out[j].Kind
+		}
+		return out[i].Name < out[j].Name
+	})
+	return out
+}
+
+// overlap records a match dropped in favor of another one covering some of
+// the same bytes.
+type overlap struct {
+	dropped Match
+	kept    Match
+}
+
+// preferMatch tells if a should be kept over b when they overlap: the
+// longer signature wins, then the one with more symbols, then the stronger.
+func preferMatch(a, b Match) bool {
+	if a.End-a.Start != b.End-b.Start {
+		return a.End-a.Start > b.End-b.Start
+	}
+	if len(a.Symbols) != len(b.Symbols) {
+		return len(a.Symbols) > len(b.Symbols)
+	}
+	return a.Strength > b.Strength
+}
+
+// resolveOverlaps removes from matches every match intersecting a preferred
+// one, so that the remaining matches describe non-overlapping segments.
+// Matches are kept from the most preferred down, so a match only goes when
+// one that is kept covers some of its bytes, not when it only overlaps a
+// match that lost to a third one.
+func resolveOverlaps(matches map[matchKey]Match) []overlap {
+	ranked := getMatchesSorted(matches)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return preferMatch(ranked[i], ranked[j])
+	})
+	var dropped []overlap
+	var kept []Match // sorted by start, never overlapping
+	for _, m := range ranked {
+		// the kept match starting last before m ends is the only one that
+		// can overlap it, as it ends last of them
+		i, _ := slices.BinarySearchFunc(kept, m.End, func(k Match, end int) int {
+			return cmp.Compare(k.Start, end)
+		})
+		if i > 0 && kept[i-1].End > m.Start {
+			dropped = append(dropped, overlap{dropped: m, kept: kept[i-1]})
+			delete(matches, matchKey{m.Name, m.Start})
+			continue
+		}
+		kept = slices.Insert(kept, i, m)
+	}
+	return dropped
+}
@@ -0,0 +1,231 @@
+package psyq
+
+import (
+	"context"
+	"slices"
+	"sync/atomic"
+	"testing"
+)
+
+func mustParse(t testing.TB, pattern string) Signature {
+	t.Helper()
+	sig := Signature{Name: "TEST.OBJ", Signature: pattern}
+	if err := sig.parse(); err != nil {
+		t.Fatal(err)
+	}
+	return sig
+}
+
+func TestFindAllMatchesAtEnd(t *testing.T) {
+	b := []byte{0x00, 0x11, 0x22, 0x33, 0x44}
+	for _, tt := range []struct {
+		pattern string
+		want    []int
+	}{
+		{"33 44", []int{3}},
+		{"22 ?? 44", []int{2}},
+		{"00 11 22 33 44", []int{0}},
+		{"33 44 ??", nil},
+		{"33 44 55", nil},
+		{"00 11 22 33 44 ??", nil},
+	} {
+		got := findAllMatches(b, mustParse(t, tt.pattern))
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%q: got %v, want %v", tt.pattern, got, tt.want)
+		}
+	}
+}
+
+// FuzzNextMatch checks that the skip table never changes where a signature
+// matches, compared to trying every offset.
+func FuzzNextMatch(f *testing.F) {
+	f.Add([]byte("\x00\x11\x22\x33\x44\x11\x22\x33"), []byte("\x11\x22\x33"), uint64(0), uint16(0))
+	f.Add([]byte("aaaaaaaaaaab"), []byte("aab"), uint64(0b010), uint16(3))
+	f.Add([]byte("abcabcabd"), []byte("abd"), uint64(0b111), uint16(0))
+	f.Add(make([]byte, 64), []byte("\x00\x00\x01\x00"), uint64(0b1100), uint16(60))
+	f.Fuzz(func(t *testing.T, data, pattern []byte, mask uint64, at uint16) {
+		if len(pattern) == 0 || len(pattern) > 64 {
+			return
+		}
+		if len(data) >= len(pattern) {
+			// plant the pattern so that matches are not only by chance
+			copy(data[int(at)%(len(data)-len(pattern)+1):], pattern)
+		}
+		sig := Signature{signature: pattern, wildcard: make([]bool, len(pattern))}
+		for i := range pattern {
+			sig.wildcard[i] = mask&(1<<i) != 0
+			if sig.wildcard[i] {
+				pattern[i] = 0
+			}
+		}
+		sig.compile()
+		for from := 0; from <= len(data); from++ {
+			got, want := nextMatch(data, sig, from), nextMatchNaive(data, sig, from)
+			if got != want {
+				t.Fatalf("from %d: nextMatch = %d, nextMatchNaive = %d", from, got, want)
+			}
+		}
+	})
+}
+
+// doneAfter is a context done once Err has been asked n times.
+type doneAfter struct {
+	context.Context
+	n atomic.Int32
+}
+
+func (c *doneAfter) Err() error {
+	if c.n.Add(-1) < 0 {
+		return context.Canceled
+	}
+	return nil
+}
+
+func TestGetMatchesParallelStops(t *testing.T) {
+	sig := mustParse(t, "11 22 33 44")
+	signatures := slices.Repeat([]Signature{sig}, 10*checkEvery)
+	data := []byte{0x11, 0x22, 0x33, 0x44}
+	sem := make(chan struct{}, 1)
+	matches, err := getMatchesParallel(context.Background(), data, 0, "t", signatures, matchOptions{}, sem)
+	if err != nil || len(matches) != len(signatures) {
+		t.Fatalf("%d matches, %v, want %d", len(matches), err, len(signatures))
+	}
+	// done midway through the only chunk
+	ctx := &doneAfter{Context: context.Background()}
+	ctx.n.Store(2)
+	matches, err = getMatchesParallel(ctx, data, 0, "t", signatures, matchOptions{}, sem)
+	if err == nil || matches != nil {
+		t.Errorf("%d matches, %v, want none and an error", len(matches), err)
+	}
+	if n := ctx.n.Load(); n < -2 {
+		t.Errorf("context checked %d times past done, want the chunk to stop", -n)
+	}
+}
+
+func TestResolveOverlaps(t *testing.T) {
+	m := func(name string, start, end, symbols, strength int) Match {
+		match := Match{Name: name, Start: start, End: end, Strength: strength, Symbols: map[uint32]string{}}
+		for i := range symbols {
+			match.Symbols[uint32(i)] = name
+		}
+		return match
+	}
+	for _, tt := range []struct {
+		name    string
+		matches []Match
+		want    []string
+	}{
+		{"disjoint", []Match{m("A", 0, 10, 0, 0), m("B", 10, 20, 0, 0)}, []string{"A", "B"}},
+		{"longer wins", []Match{m("A", 0, 10, 0, 0), m("B", 5, 25, 0, 0)}, []string{"B"}},
+		{"more symbols win a tie", []Match{m("A", 0, 10, 1, 0), m("B", 5, 15, 2, 0)}, []string{"B"}},
+		{"stronger wins a tie", []Match{m("A", 0, 10, 1, 8), m("B", 5, 15, 1, 4)}, []string{"A"}},
+		{"contained", []Match{m("A", 0, 100, 0, 0), m("B", 10, 20, 0, 0)}, []string{"A"}},
+		{
+			// B only loses to A, which loses to C: B is kept
+			"chain", []Match{m("A", 0, 100, 0, 0), m("B", 50, 60, 0, 0), m("C", 90, 300, 0, 0)},
+			[]string{"B", "C"},
+		},
+		{
+			"chain both sides", []Match{m("A", 0, 30, 0, 0), m("B", 20, 70, 0, 0), m("C", 60, 90, 0, 0)},
+			[]string{"B"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			matches := map[matchKey]Match{}
+			for _, match := range tt.matches {
+				matches[matchKey{match.Name, match.Start}] = match
+			}
+			dropped := resolveOverlaps(matches)
+			var got []string
+			for _, match := range getMatchesSorted(matches) {
+				got = append(got, match.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("kept %v, want %v", got, tt.want)
+			}
+			if len(dropped) != len(tt.matches)-len(tt.want) {
+				t.Errorf("%d dropped reported, want %d", len(dropped), len(tt.matches)-len(tt.want))
+			}
+			for _, o := range dropped {
+				if _, ok := matches[matchKey{o.kept.Name, o.kept.Start}]; !ok {
+					t.Errorf("%s dropped in favor of %s, which was dropped too", o.dropped.Name, o.kept.Name)
+				}
+			}
+		})
+	}
+}
+
+func TestMatchStrength(t *testing.T) {
+	b := []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	for _, tt := range []struct {
+		pattern string
+		want    int
+	}{
+		{"11 22 33 44", 4},
+		{"11 ?? ?? 44", 2},
+		{"?? ?? ?? ?? 55 66 77 ??", 3},
+		{"11 22 33 44 55 66 77 88", 8},
+	} {
+		matches := getMatches(b, 0, "t", []Signature{mustParse(t, tt.pattern)}, matchOptions{})
+		if len(matches) != 1 {
+			t.Fatalf("%q: %d matches, want 1", tt.pattern, len(matches))
+		}
+		if matches[0].Strength != tt.want {
+			t.Errorf("%q: strength %d, want %d", tt.pattern, matches[0].Strength, tt.want)
+		}
+	}
+}
+
+func TestLabelFilterKeep(t *testing.T) {
+	for _, tt := range []struct {
+		name             string
+		include, exclude []string
+		keep             []string
+		drop             []string
+	}{
+		{
+			name:    "default",
+			exclude: DefaultExcludePrefixes,
+			keep:    []string{"InitGeom", "location"},
+			drop:    []string{"loc_80", "text_1"},
+		},
+		{
+			name:    "include wins a tie",
+			include: []string{"loc_"},
+			exclude: DefaultExcludePrefixes,
+			keep:    []string{"loc_80"},
+			drop:    []string{"InitGeom", "text_1"},
+		},
+		{
+			name:    "longer exclude wins",
+			include: []string{"Lib"},
+			exclude: []string{"LibFoo"},
+			keep:    []string{"LibBar"},
+			drop:    []string{"LibFooBar", "Other"},
+		},
+		{
+			name:    "longer include wins",
+			include: []string{"LibFoo"},
+			exclude: []string{"Lib"},
+			keep:    []string{"LibFooBar"},
+			drop:    []string{"LibBar"},
+		},
+		{
+			name:    "nothing filtered",
+			exclude: []string{},
+			keep:    []string{"loc_80", "InitGeom"},
+		},
+	} {
+		f := labelFilter{include: tt.include, exclude: tt.exclude}
+		for _, name := range tt.keep {
+			if !f.keep(name) {
+				t.Errorf("%s: %s dropped", tt.name, name)
+			}
+		}
+		for _, name := range tt.drop {
+			if f.keep(name) {
+				t.Errorf("%s: %s kept", tt.name, name)
+			}
+		}
+	}
+}
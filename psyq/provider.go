@@ -0,0 +1,275 @@
+package psyq
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SignatureProvider retrieves the parsed signatures of a PSY-Q SDK version.
+type SignatureProvider interface {
+	Signatures(ctx context.Context, version string) ([]Signature, error)
+}
+
+// GitHubProvider downloads the signatures from a GitHub repository laid out
+// like lab313ru/psx_psyq_signatures.
+type GitHubProvider struct {
+	client      *githubClient
+	repo        githubRepo
+	cache       *SignatureCache
+	skipInvalid bool
+}
+
+// The repository signatures are downloaded from by default.
+const (
+	DefaultOwner = "lab313ru"
+	DefaultRepo  = "psx_psyq_signatures"
+)
+
+// GitHubOptions configures a GitHubProvider.
+type GitHubOptions struct {
+	// Owner and Repo name the repository, DefaultOwner/DefaultRepo when
+	// empty.
+	Owner string
+	Repo  string
+	// Branch to read, the default branch of the repository when empty.
+	Branch string
+	// Token authenticates the requests when not empty.
+	Token string
+	// Concurrency bounds the requests in flight at once,
+	// DefaultConcurrency when zero.
+	Concurrency int
+	// MaxAttempts bounds how many times a request failing with a transient
+	// error is tried, DefaultMaxAttempts when zero.
+	MaxAttempts int
+	// SkipInvalid leaves out the signatures that fail to parse, logging a
+	// warning, instead of failing the whole version.
+	SkipInvalid bool
+	// Cache stores the downloaded signatures when not nil.
+	Cache *SignatureCache
+	// Logger receives diagnostic messages, discarded when nil.
+	Logger *slog.Logger
+}
+
+// DefaultMaxAttempts is the number of times a GitHub request is tried when
+// GitHubOptions.MaxAttempts is not set.
+const DefaultMaxAttempts = 3
+
+// NewGitHubProvider returns a provider configured by opts.
+func NewGitHubProvider(opts GitHubOptions) *GitHubProvider {
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.MaxAttempts <= 0 {
+		opts.MaxAttempts = DefaultMaxAttempts
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	if opts.Owner == "" && opts.Repo == "" {
+		opts.Owner, opts.Repo = DefaultOwner, DefaultRepo
+	}
+	return &GitHubProvider{
+		repo:        githubRepo{owner: opts.Owner, name: opts.Repo, ref: opts.Branch},
+		client:      newGitHubClient(opts.Token, opts.Concurrency, opts.MaxAttempts, opts.Logger),
+		cache:       opts.Cache,
+		skipInvalid: opts.SkipInvalid,
+	}
+}
+
+func (p *GitHubProvider) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	return fetchPsyqSignatures(ctx, p.client, p.repo, version, p.cache, p.skipInvalid)
+}
+
+// LocalProvider reads the signatures from a local psx_psyq_signatures clone
+// rooted at Dir.
+type LocalProvider struct {
+	Dir string
+	// SkipInvalid leaves out the signatures that fail to parse, logging a
+	// warning to Logger, instead of failing the whole version.
+	SkipInvalid bool
+	Logger      *slog.Logger
+}
+
+func (p *LocalProvider) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	libraries, err := readLocalSignatures(p.Dir, version)
+	if err != nil {
+		return nil, err
+	}
+	return parseLibraries(libraries, version, p.SkipInvalid, p.Logger)
+}
+
+// parseLibraries parses the signatures of a version, skipping and logging
+// the invalid ones when skipInvalid is set, and merging the duplicates.
+func parseLibraries(libraries map[string][]Signature, version string, skipInvalid bool, logger *slog.Logger) ([]Signature, error) {
+	if logger == nil {
+		logger = slog.New(slog.DiscardHandler)
+	}
+	var skip func(error)
+	if skipInvalid {
+		skip = func(err error) {
+			logger.Warn("skipping invalid signature", "version", version, "err", err)
+		}
+	}
+	signatures, err := parseSignatures(libraries, skip)
+	if err != nil {
+		return nil, err
+	}
+	return mergeDuplicates(signatures, version, logger), nil
+}
+
+// mergeDuplicates drops the signatures repeating the name and pattern of an
+// earlier one, as different files of a version can carry the same object.
+// Signatures sharing only the name are kept, with a warning naming where
+// both come from, since one of them is likely wrong upstream.
+func mergeDuplicates(signatures []Signature, version string, logger *slog.Logger) []Signature {
+	seen := map[string][]int{}
+	out := signatures[:0:0]
+	for _, sig := range signatures {
+		name := NormalizeObjectName(sig.Name)
+		merged := false
+		for _, i := range seen[name] {
+			prev := out[i]
+			if bytes.Equal(prev.signature, sig.signature) && slices.Equal(prev.wildcard, sig.wildcard) {
+				logger.Debug("merged duplicate signature", "version", version, "name", name, "libraries", prev.Library+","+sig.Library)
+				merged = true
+				break
+			}
+			logger.Warn("signature name defined twice with different patterns", "version", version, "name", name, "libraries", prev.Library+","+sig.Library)
+		}
+		if merged {
+			continue
+		}
+		seen[name] = append(seen[name], len(out))
+		out = append(out, sig)
+	}
+	return out
+}
+
+// StaticProvider serves in-memory signatures, keyed by version. Their
+// Library is kept as is.
+type StaticProvider map[string][]Signature
+
+func (p StaticProvider) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	items, ok := p[version]
+	if !ok {
+		return nil, fmt.Errorf("no signatures for PSY-Q %s", version)
+	}
+	signatures := make([]Signature, len(items))
+	for i, signature := range items {
+		if err := signature.parse(); err != nil {
+			return nil, err
+		}
+		signatures[i] = signature
+	}
+	return signatures, nil
+}
+
+// readLocalSignatures reads the signatures of an SDK version from a local
+// clone of psx_psyq_signatures, keyed by library file like the download.
+func readLocalSignatures(dir, sdkver string) (map[string][]Signature, error) {
+	verDir := filepath.Join(dir, sdkver)
+	if _, err := os.Stat(verDir); err != nil {
+		return nil, fmt.Errorf("signatures for PSY-Q %s not found, expected directory %s", sdkver, verDir)
+	}
+	files, err := filepath.Glob(filepath.Join(verDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := filepath.Glob(filepath.Join(verDir, "*.json.gz"))
+	if err != nil {
+		return nil, err
+	}
+	libraries := map[string][]Signature{}
+	for _, file := range append(files, compressed...) {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+		name, items, err := decodeLibrary(filepath.Base(file), f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		libraries[name] = append(libraries[name], items...)
+	}
+	return libraries, nil
+}
+
+// decodeLibrary decodes the signatures of a library file, decompressing it
+// when its name ends in .gz. It returns the name of the file once
+// decompressed, which the library is named after.
+func decodeLibrary(name string, r io.Reader) (string, []Signature, error) {
+	if base, ok := strings.CutSuffix(name, ".gz"); ok {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return "", nil, err
+		}
+		defer zr.Close()
+		name, r = base, zr
+	}
+	var items []Signature
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return "", nil, err
+	}
+	return name, items, nil
+}
+
+// memoProvider remembers the signatures returned by another provider, so
+// each version is fetched and parsed at most once per process.
+type memoProvider struct {
+	provider SignatureProvider
+	mu       sync.Mutex
+	entries  map[string]*memoEntry
+}
+
+type memoEntry struct {
+	ready      chan struct{} // closed once signatures or err are set
+	signatures []Signature
+	err        error
+}
+
+// Memoize wraps a provider so that the signatures of a version are only
+// retrieved once, concurrent callers waiting for the first one to finish.
+// Failures are not remembered and the next call tries again. The returned
+// signatures are shared between callers and must not be modified.
+func Memoize(provider SignatureProvider) SignatureProvider {
+	return &memoProvider{
+		provider: provider,
+		entries:  map[string]*memoEntry{},
+	}
+}
+
+func (p *memoProvider) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	p.mu.Lock()
+	e, ok := p.entries[version]
+	if !ok {
+		e = &memoEntry{ready: make(chan struct{})}
+		p.entries[version] = e
+		p.mu.Unlock()
+		e.signatures, e.err = p.provider.Signatures(ctx, version)
+		if e.err != nil {
+			p.mu.Lock()
+			delete(p.entries, version)
+			p.mu.Unlock()
+		}
+		close(e.ready)
+		return e.signatures, e.err
+	}
+	p.mu.Unlock()
+	select {
+	case <-e.ready:
+		return e.signatures, e.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
@@ -0,0 +1,106 @@
+package psyq
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// writeLibraries lays out the library files of a version under dir, like a
+// psx_psyq_signatures clone.
+func writeLibraries(t *testing.T, dir, version string, files map[string][]byte) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Join(dir, version), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, version, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// testLogger returns a logger writing to the returned buffer.
+func testLogger() (*slog.Logger, *bytes.Buffer) {
+	var buf bytes.Buffer
+	return slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})), &buf
+}
+
+func TestLocalProviderInvalidSignature(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraries(t, dir, "400", map[string][]byte{
+		"LIBA.json": []byte(`[{"name":"GOOD.OBJ","sig":"01 02"},{"name":"BAD.OBJ","sig":"01 ZZ"}]`),
+	})
+
+	_, err := (&LocalProvider{Dir: dir}).Signatures(context.Background(), "400")
+	if err == nil || !strings.Contains(err.Error(), "LIBA.json") || !strings.Contains(err.Error(), "BAD.OBJ") {
+		t.Errorf("err = %v, want the file and object of the invalid signature", err)
+	}
+
+	logger, logs := testLogger()
+	signatures, err := (&LocalProvider{Dir: dir, SkipInvalid: true, Logger: logger}).Signatures(context.Background(), "400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(signatures) != 1 || signatures[0].Name != "GOOD.OBJ" {
+		t.Errorf("signatures %+v, want only GOOD.OBJ", signatures)
+	}
+	if !strings.Contains(logs.String(), "level=WARN msg=\"skipping invalid signature\"") || !strings.Contains(logs.String(), "BAD.OBJ") {
+		t.Errorf("no warning about BAD.OBJ in\n%s", logs)
+	}
+}
+
+func TestLocalProviderGzip(t *testing.T) {
+	dir := t.TempDir()
+	writeLibraries(t, dir, "400", map[string][]byte{
+		"LIBA.json":    []byte(`[{"name":"A.OBJ","sig":"01 02"}]`),
+		"LIBB.json.gz": gzipped(t, `[{"name":"B.OBJ","sig":"03 04"}]`),
+	})
+	signatures, err := (&LocalProvider{Dir: dir}).Signatures(context.Background(), "400")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sig := range signatures {
+		got = append(got, sig.Library+"/"+sig.Name)
+	}
+	if want := []string{"LIBA/A.OBJ", "LIBB/B.OBJ"}; !slices.Equal(got, want) {
+		t.Errorf("signatures %v, want %v", got, want)
+	}
+}
+
+func TestParseLibrariesDuplicates(t *testing.T) {
+	logger, logs := testLogger()
+	signatures, err := parseLibraries(map[string][]Signature{
+		"LIBA.json": {
+			{Name: "SAME.OBJ", Signature: "01 02 ?? 04"},
+			{Name: "DIFF.OBJ", Signature: "05 06"},
+		},
+		"LIBB.json": {
+			{Name: "same.obj", Signature: "01 02 ?? 04"},
+			{Name: "DIFF.OBJ", Signature: "05 07"},
+		},
+	}, "400", false, logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, sig := range signatures {
+		got = append(got, sig.Library+"/"+NormalizeObjectName(sig.Name))
+	}
+	// the identical SAME.OBJ of LIBB is merged, both DIFF.OBJ are kept
+	if want := []string{"LIBA/DIFF.OBJ", "LIBA/SAME.OBJ", "LIBB/DIFF.OBJ"}; !slices.Equal(got, want) {
+		t.Errorf("signatures %v, want %v", got, want)
+	}
+	if n := strings.Count(logs.String(), "signature name defined twice with different patterns"); n != 1 {
+		t.Errorf("%d warnings about DIFF.OBJ, want 1:\n%s", n, logs)
+	}
+	if !strings.Contains(logs.String(), "name=DIFF.OBJ libraries=LIBA,LIBB") {
+		t.Errorf("warning does not name DIFF.OBJ and both libraries:\n%s", logs)
+	}
+}
@@ -0,0 +1,511 @@
+// Package psyq identifies the PSY-Q SDK objects statically linked into a
+// PlayStation executable, using the signatures of lab313ru's
+// psx_psyq_signatures, and recovers the symbols they define.
+package psyq
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"sync/atomic"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Versions lists every SDK version with signatures available.
+var Versions = []string{
+	"260", "300", "330", "340", "350", "3610", "3611", "370",
+	"400", "410", "420", "430", "440", "450", "460", "470",
+}
+
+// snapPadding bounds the padding Options.SnapEnds absorbs: the text of an
+// object is aligned to at most 16 bytes, anything larger between two
+// matches is code no signature identified.
+const snapPadding = 16
+
+// DefaultConcurrency is the number of versions scanned at once when
+// Options.Concurrency is not set.
+const DefaultConcurrency = 8
+
+// VersionEstimate is the fraction of the matches that come from an SDK
+// version.
+type VersionEstimate struct {
+	Version string  `json:"version"`
+	Match   float64 `json:"match"`
+}
+
+// Symbol is a label recovered from a match, at its absolute address.
+type Symbol struct {
+	Name    string `json:"name"`
+	Address uint32 `json:"address"`
+	Kind    string `json:"kind"` // SymbolText, SymbolBss or SymbolReloc
+}
+
+const (
+	SymbolText  = "text"  // code label
+	SymbolBss   = "bss"   // uninitialized data label, from the xbss section
+	SymbolReloc = "reloc" // unnamed jump target, from a resolved relocation
+)
+
+// ObjectCoverage tells how many of the known signatures of a library were
+// found in the scanned binary.
+type ObjectCoverage struct {
+	Library string `json:"library"`
+	Found   int    `json:"found"`
+	Total   int    `json:"total"`
+}
+
+// Result is the outcome of a scan. Matches do not overlap and are sorted by
+// start offset, symbols are sorted by address.
+type Result struct {
+	BaseAddr uint32            `json:"base_addr"` // address of the first scanned byte
+	Versions []VersionEstimate `json:"versions"`
+	Matches  []Match           `json:"matches"`
+	Symbols  []Symbol          `json:"symbols"`
+	Coverage []ObjectCoverage  `json:"coverage,omitempty"`
+	Summary  Summary           `json:"summary"`
+	// Conflicts lists the symbol names defined at more than one address.
+	Conflicts []SymbolConflict `json:"conflicts,omitempty"`
+	// Ambiguities lists the objects matched at more than one offset.
+	Ambiguities []Ambiguity `json:"ambiguities,omitempty"`
+}
+
+// Ambiguity is an object found at several offsets, either because its
+// signature is too short to be unique or because the code is duplicated.
+type Ambiguity struct {
+	Name   string `json:"name"`
+	Starts []int  `json:"starts"`
+}
+
+// Summary tells how much of the scanned data the matches identify.
+type Summary struct {
+	Size    int `json:"size"`    // scanned bytes
+	Matched int `json:"matched"` // bytes covered by a match
+	// Gaps lists the largest ranges no match covers, largest first, as
+	// many as Options.Gaps asks.
+	Gaps []Gap `json:"gaps,omitempty"`
+}
+
+// Gap is a range of the scanned data left unidentified. Start is an offset
+// from the beginning of the scanned data.
+type Gap struct {
+	Start  int `json:"start"`
+	Length int `json:"length"`
+}
+
+// SymbolConflict is a symbol name that matches place at different
+// addresses, which linker scripts expecting unique names reject.
+type SymbolConflict struct {
+	Name      string   `json:"name"`
+	Addresses []uint32 `json:"addresses"`
+}
+
+// Region is a range of Size bytes of the address space, from Addr.
+type Region struct {
+	Addr uint32 `json:"addr"`
+	Size uint32 `json:"size"`
+}
+
+// overlaps tells if the region intersects the addresses from start to end,
+// end exclusive.
+func (r Region) overlaps(start, end uint32) bool {
+	return uint64(start) < uint64(r.Addr)+uint64(r.Size) && end > r.Addr
+}
+
+// Options configures a scan.
+type Options struct {
+	// Provider retrieves the signatures of each version, it is required.
+	Provider SignatureProvider
+	// Versions to scan, every one of Versions when empty.
+	Versions []string
+	// Concurrency bounds how many versions are scanned at once, and how many
+	// chunks of signatures are matched at once across them,
+	// DefaultConcurrency when zero.
+	Concurrency int
+	// MinSymbols drops the matches defining fewer text symbols than this.
+	MinSymbols int
+	// DataRegions drops the matches intersecting any of these regions,
+	// known to hold data rather than code.
+	DataRegions []Region
+	// CoverageByObject fills Result.Coverage.
+	CoverageByObject bool
+	// ExcludeAmbiguous leaves the symbols of ambiguous matches out of the
+	// result, rather than risking naming the wrong address.
+	ExcludeAmbiguous bool
+	// ConfidenceThreshold, when positive, stops scanning the other
+	// versions as soon as the matches of one version cover at least this
+	// fraction of data. The ones still running stop too, and are left out
+	// of the result like the ones not started yet. Which versions were
+	// scanned then depends on the order they finish in.
+	ConfidenceThreshold float64
+	// SnapEnds extends the End of every match to the Start of the next
+	// one when only alignment padding, less than snapPadding bytes, sits
+	// between them, for the tools expecting contiguous function ranges.
+	// Larger gaps are unidentified code and are left as they are, as is
+	// the End of the last match. The summary still counts the bytes
+	// matched by the signatures.
+	SnapEnds bool
+	// Gaps is how many of the largest unmatched ranges Result.Summary lists.
+	Gaps int
+	// ResolveRelocs decodes the relocated operands of every match, adding
+	// the jump targets they point to as symbols.
+	ResolveRelocs bool
+	// IncludePrefixes, when not empty, only reports the labels starting
+	// with one of them as symbols.
+	IncludePrefixes []string
+	// ExcludePrefixes drops the labels starting with one of them,
+	// DefaultExcludePrefixes when nil. The longest matching prefix of
+	// either list decides, an include one winning a tie.
+	ExcludePrefixes []string
+	// Logger receives diagnostic messages, discarded when nil.
+	Logger *slog.Logger
+}
+
+// Scan matches data, loaded at baseAddr, against the signatures of every
+// requested SDK version. Each version is matched as by ScanVersion, with
+// the label and relocation options of opts, then their matches are merged
+// into non-overlapping segments.
+func Scan(data []byte, baseAddr uint32, opts Options) (*Result, error) {
+	return ScanContext(context.Background(), data, baseAddr, opts)
+}
+
+// ScanContext is like Scan, aborting when ctx is done.
+func ScanContext(ctx context.Context, data []byte, baseAddr uint32, opts Options) (*Result, error) {
+	if opts.Provider == nil {
+		return nil, errors.New("psyq: no signature provider")
+	}
+	opts = opts.withDefaults()
+	versions, concurrency, logger := opts.Versions, opts.Concurrency, opts.Logger
+	matchOpts := opts.matchOptions()
+
+	// every version is scanned in its own slot and merged in order once all
+	// are done, so the result does not depend on which finished first
+	parent := ctx
+	ctx, skipRest := context.WithCancel(ctx)
+	defer skipRest()
+	var confident atomic.Bool
+	eg, ctx := errgroup.WithContext(ctx)
+	eg.SetLimit(concurrency)
+	slots := make([]scanned, len(versions))
+	sem := make(chan struct{}, concurrency)
+	for i, ver := range versions {
+		eg.Go(func() error {
+			signatures, matches, err := scanVersion(ctx, data, baseAddr, ver, opts.Provider, matchOpts, sem, logger)
+			if err != nil {
+				if confident.Load() && parent.Err() == nil {
+					return nil // skipped, another version is enough
+				}
+				return err
+			}
+			slots[i] = scanned{signatures, matches}
+			if opts.ConfidenceThreshold > 0 && len(data) > 0 {
+				coverage := float64(coveredBytes(matches)) / float64(len(data))
+				if coverage >= opts.ConfidenceThreshold && !confident.Swap(true) {
+					logger.Debug("confident version found, skipping the remaining ones", "version", ver, "coverage", coverage)
+					skipRest()
+				}
+			}
+			return nil
+		})
+	}
+	if err := eg.Wait(); err != nil {
+		return nil, err
+	}
+	return mergeScans(slots, len(data), baseAddr, opts), nil
+}
+
+// withDefaults returns the options with the defaults of the unset fields
+// filled in.
+func (opts Options) withDefaults() Options {
+	if len(opts.Versions) == 0 {
+		opts.Versions = Versions
+	}
+	if opts.Concurrency <= 0 {
+		opts.Concurrency = DefaultConcurrency
+	}
+	if opts.Logger == nil {
+		opts.Logger = slog.New(slog.DiscardHandler)
+	}
+	return opts
+}
+
+func (opts Options) matchOptions() matchOptions {
+	matchOpts := matchOptions{
+		relocs: opts.ResolveRelocs,
+		labels: labelFilter{include: opts.IncludePrefixes, exclude: opts.ExcludePrefixes},
+	}
+	if matchOpts.labels.exclude == nil {
+		matchOpts.labels.exclude = DefaultExcludePrefixes
+	}
+	return matchOpts
+}
+
+// scanned holds the signatures of a version and their matches.
+type scanned struct {
+	signatures []Signature
+	matches    []Match
+}
+
+// mergeScans merges the matches of every version, in order, into the
+// result of scanning size bytes loaded at baseAddr.
+func mergeScans(slots []scanned, size int, baseAddr uint32, opts Options) *Result {
+	logger := opts.Logger
+	allMatches := map[matchKey]Match{}
+	known := map[string]map[string]bool{}
+	for _, slot := range slots {
+		for _, sig := range slot.signatures {
+			if known[sig.Library] == nil {
+				known[sig.Library] = make(map[string]bool)
+			}
+			known[sig.Library][NormalizeObjectName(sig.Name)] = true
+		}
+		for _, match := range slot.matches {
+			key := matchKey{match.Name, match.Start}
+			existingMatch, ok := allMatches[key]
+			if !ok {
+				allMatches[key] = match
+				continue
+			}
+			// If the same match is found across different PSY-Q versions,
+			// take the match with the highest symbol matches found, then
+			// the one with the most concrete bytes, then the one of the
+			// version requested first
+			if len(existingMatch.Symbols) != len(match.Symbols) {
+				if len(existingMatch.Symbols) < len(match.Symbols) {
+					allMatches[key] = match
+				}
+			} else if existingMatch.Strength < match.Strength {
+				allMatches[key] = match
+			}
+		}
+	}
+
+	for key, m := range allMatches {
+		if len(m.Symbols) < opts.MinSymbols {
+			delete(allMatches, key)
+			continue
+		}
+		start, end := baseAddr+uint32(m.Start), baseAddr+uint32(m.End)
+		if slices.ContainsFunc(opts.DataRegions, func(r Region) bool { return r.overlaps(start, end) }) {
+			logger.Debug(fmt.Sprintf("dropped match %s at 0x%X inside a data region", m.Name, m.Start))
+			delete(allMatches, key)
+		}
+	}
+	for _, o := range resolveOverlaps(allMatches) {
+		logger.Debug(fmt.Sprintf("dropped overlapping match %s at 0x%X in favor of %s at 0x%X",
+			o.dropped.Name, o.dropped.Start, o.kept.Name, o.kept.Start))
+	}
+	ambiguities := findAmbiguities(allMatches)
+	for key, m := range allMatches {
+		if !slices.ContainsFunc(ambiguities, func(a Ambiguity) bool { return a.Name == m.Name }) {
+			continue
+		}
+		m.Ambiguous = true
+		if opts.ExcludeAmbiguous {
+			m.Symbols, m.Bss, m.Relocs = nil, nil, nil
+		}
+		allMatches[key] = m
+	}
+	result := &Result{
+		BaseAddr:    baseAddr,
+		Ambiguities: ambiguities,
+		Versions:    estimatePsyqVesion(allMatches),
+		Matches:     getMatchesSorted(allMatches),
+		Symbols:     getSymbolsSorted(allMatches),
+	}
+	result.Conflicts = findSymbolConflicts(result.Symbols)
+	result.Summary = summarize(size, result.Matches, opts.Gaps)
+	if opts.SnapEnds {
+		for i := 0; i+1 < len(result.Matches); i++ {
+			if next := result.Matches[i+1].Start; next-result.Matches[i].End < snapPadding {
+				result.Matches[i].End = next
+			}
+		}
+	}
+	if opts.CoverageByObject {
+		result.Coverage = getCoverageByObject(known, allMatches)
+	}
+	return result
+}
+
+// ScanVersion matches data, loaded at baseAddr, against the signatures of a
+// single SDK version. Unlike a Scan, every match is returned, sorted by
+// start offset, even when it overlaps others. The labels starting with
+// one of DefaultExcludePrefixes are left out and relocations are not
+// resolved; a Scan of that single version honors the other options.
+func ScanVersion(data []byte, baseAddr uint32, version string, provider SignatureProvider) ([]Match, error) {
+	return ScanVersionContext(context.Background(), data, baseAddr, version, provider)
+}
+
+// ScanVersionContext is like ScanVersion, aborting when ctx is done.
+func ScanVersionContext(ctx context.Context, data []byte, baseAddr uint32, version string, provider SignatureProvider) ([]Match, error) {
+	if provider == nil {
+		return nil, errors.New("psyq: no signature provider")
+	}
+	sem := make(chan struct{}, DefaultConcurrency)
+	opts := matchOptions{labels: labelFilter{exclude: DefaultExcludePrefixes}}
+	_, matches, err := scanVersion(ctx, data, baseAddr, version, provider, opts, sem, slog.New(slog.DiscardHandler))
+	if err != nil {
+		return nil, err
+	}
+	slices.SortStableFunc(matches, func(a, b Match) int {
+		return a.Start - b.Start
+	})
+	return matches, nil
+}
+
+// scanVersion fetches the signatures of a version and matches them.
+func scanVersion(ctx context.Context, data []byte, baseAddr uint32, version string, provider SignatureProvider, opts matchOptions, sem chan struct{}, logger *slog.Logger) ([]Signature, []Match, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	signatures, err := provider.Signatures(ctx, version)
+	if err != nil {
+		return nil, nil, fmt.Errorf("PSY-Q %s: %w", version, err)
+	}
+	logger.Debug("fetched signatures", "version", version, "count", len(signatures))
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	matches, err := getMatchesParallel(ctx, data, baseAddr, version, signatures, opts, sem)
+	if err != nil {
+		return nil, nil, err
+	}
+	return signatures, matches, nil
+}
+
+func estimatePsyqVesion(matches map[matchKey]Match) []VersionEstimate {
+	versions := make(map[string]int)
+	for _, m := range matches {
+		versions[m.Version]++
+	}
+	total := float64(len(matches))
+	out := make([]VersionEstimate, 0, len(versions))
+	for v, count := range versions {
+		out = append(out, VersionEstimate{
+			Version: v,
+			Match:   float64(count) / total,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Match != out[j].Match {
+			return out[i].Match > out[j].Match
+		}
+		return out[i].Version < out[j].Version
+	})
+	if len(out) >= 3 {
+		out = out[:3]
+	}
+	return out
+}
+
+// getCoverageByObject reports, for every library with at least one match,
+// how many of its known signatures were found in the scanned binary.
+func getCoverageByObject(known map[string]map[string]bool, matches map[matchKey]Match) []ObjectCoverage {
+	found := make(map[string]map[string]bool)
+	for _, m := range matches {
+		if found[m.Library] == nil {
+			found[m.Library] = make(map[string]bool)
+		}
+		found[m.Library][m.Name] = true
+	}
+	out := make([]ObjectCoverage, 0, len(found))
+	for library, names := range found {
+		out = append(out, ObjectCoverage{
+			Library: library,
+			Found:   len(names),
+			Total:   len(known[library]),
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].Library < out[j].Library
+	})
+	return out
+}
+
+// coveredBytes returns how many bytes at least one of matches covers.
+func coveredBytes(matches []Match) int {
+	sorted := slices.SortedFunc(slices.Values(matches), func(a, b Match) int {
+		return a.Start - b.Start
+	})
+	covered, end := 0, 0
+	for _, m := range sorted {
+		start := max(m.Start, end)
+		if m.End > start {
+			covered += m.End - start
+			end = m.End
+		}
+	}
+	return covered
+}
+
+// findAmbiguities returns the objects with more than one match, sorted by
+// their first offset.
+func findAmbiguities(matches map[matchKey]Match) []Ambiguity {
+	starts := map[string][]int{}
+	var names []string
+	for _, m := range getMatchesSorted(matches) {
+		if _, ok := starts[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		starts[m.Name] = append(starts[m.Name], m.Start)
+	}
+	var out []Ambiguity
+	for _, name := range names {
+		if len(starts[name]) > 1 {
+			out = append(out, Ambiguity{Name: name, Starts: starts[name]})
+		}
+	}
+	return out
+}
+
+// summarize counts the bytes covered by matches, which must be sorted and
+// not overlap, out of size, and lists the largest n gaps between them.
+func summarize(size int, matches []Match, n int) Summary {
+	summary := Summary{Size: size}
+	var gaps []Gap
+	end := 0
+	for _, m := range matches {
+		summary.Matched += m.End - m.Start
+		if m.Start > end {
+			gaps = append(gaps, Gap{Start: end, Length: m.Start - end})
+		}
+		end = m.End
+	}
+	if size > end {
+		gaps = append(gaps, Gap{Start: end, Length: size - end})
+	}
+	sort.SliceStable(gaps, func(i, j int) bool {
+		return gaps[i].Length > gaps[j].Length
+	})
+	if n > 0 {
+		summary.Gaps = gaps[:min(n, len(gaps))]
+	}
+	return summary
+}
+
+// findSymbolConflicts returns the names appearing at more than one address
+// in symbols. As symbols are sorted by address, so are the conflicts and
+// their addresses.
+func findSymbolConflicts(symbols []Symbol) []SymbolConflict {
+	addrs := map[string][]uint32{}
+	var names []string
+	for _, symbol := range symbols {
+		if _, ok := addrs[symbol.Name]; !ok {
+			names = append(names, symbol.Name)
+		}
+		if !slices.Contains(addrs[symbol.Name], symbol.Address) {
+			addrs[symbol.Name] = append(addrs[symbol.Name], symbol.Address)
+		}
+	}
+	var out []SymbolConflict
+	for _, name := range names {
+		if len(addrs[name]) > 1 {
+			out = append(out, SymbolConflict{Name: name, Addresses: addrs[name]})
+		}
+	}
+	return out
+}
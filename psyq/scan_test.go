@@ -0,0 +1,253 @@
+package psyq
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testBase = DefaultBaseAddr
+
+// scanTest scans data against items, as the signatures of a single
+// version, with the rest of opts.
+func scanTest(t *testing.T, data []byte, opts Options, items ...Signature) *Result {
+	t.Helper()
+	opts.Provider = StaticProvider{"t": items}
+	opts.Versions = []string{"t"}
+	result, err := Scan(data, testBase, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+// place returns size bytes of 0xFF, with the hex bytes of a pattern at
+// every offset given.
+func place(size int, pattern []byte, offsets ...int) []byte {
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = 0xFF
+	}
+	for _, offset := range offsets {
+		copy(b[offset:], pattern)
+	}
+	return b
+}
+
+func starts(matches []Match) []int {
+	var out []int
+	for _, m := range matches {
+		out = append(out, m.Start)
+	}
+	return out
+}
+
+func TestEstimatePsyqVersionOrder(t *testing.T) {
+	matches := map[matchKey]Match{}
+	add := func(version string, n int) {
+		for range n {
+			name := fmt.Sprintf("O%d.OBJ", len(matches))
+			matches[matchKey{name, 0}] = Match{Name: name, Version: version}
+		}
+	}
+	add("260", 1)
+	add("400", 4)
+	add("420", 2)
+	add("440", 2)
+	add("470", 1)
+	var got []string
+	for _, v := range estimatePsyqVesion(matches) {
+		got = append(got, v.Version)
+	}
+	// the three most matched, ties broken by version
+	if want := []string{"400", "420", "440"}; !slices.Equal(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestScanEveryOccurrence(t *testing.T) {
+	data := place(0x80, []byte{0xDE, 0xAD, 0xBE, 0xEF, 1, 2, 3, 4}, 0x10, 0x40)
+	result := scanTest(t, data, Options{}, Signature{
+		Name:      "TWICE.OBJ",
+		Signature: "DE AD BE EF ?? ?? ?? 04",
+		Labels:    []Labels{{Name: "Twice", Offset: 4}},
+	})
+	if got := starts(result.Matches); !slices.Equal(got, []int{0x10, 0x40}) {
+		t.Fatalf("matches at %v, want 0x10 and 0x40", got)
+	}
+	want := []Symbol{
+		{Name: "Twice", Address: testBase + 0x14, Kind: SymbolText},
+		{Name: "Twice", Address: testBase + 0x44, Kind: SymbolText},
+	}
+	if !slices.Equal(result.Symbols, want) {
+		t.Errorf("symbols %v, want %v", result.Symbols, want)
+	}
+}
+
+// countingProvider serves no signatures, recording how many versions are
+// fetched at once.
+type countingProvider struct {
+	inFlight, peak atomic.Int32
+}
+
+func (p *countingProvider) Signatures(ctx context.Context, version string) ([]Signature, error) {
+	n := p.inFlight.Add(1)
+	defer p.inFlight.Add(-1)
+	for {
+		peak := p.peak.Load()
+		if n <= peak || p.peak.CompareAndSwap(peak, n) {
+			break
+		}
+	}
+	time.Sleep(5 * time.Millisecond)
+	return nil, nil
+}
+
+func TestScanConcurrency(t *testing.T) {
+	provider := &countingProvider{}
+	if _, err := Scan(make([]byte, 16), testBase, Options{Provider: provider, Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if peak := provider.peak.Load(); peak > 2 {
+		t.Errorf("%d versions scanned at once, want at most 2", peak)
+	}
+}
+
+func TestScanSymbolConflicts(t *testing.T) {
+	data := place(0x80, []byte{0x11, 0x22, 0x33, 0x44}, 0x10)
+	copy(data[0x40:], []byte{0x55, 0x66, 0x77, 0x88})
+	result := scanTest(t, data, Options{},
+		Signature{Name: "A.OBJ", Signature: "11 22 33 44", Labels: []Labels{{Name: "Clash"}, {Name: "OnlyA", Offset: 2}}},
+		Signature{Name: "B.OBJ", Signature: "55 66 77 88", Labels: []Labels{{Name: "Clash"}}},
+	)
+	want := []SymbolConflict{{Name: "Clash", Addresses: []uint32{testBase + 0x10, testBase + 0x40}}}
+	if len(result.Conflicts) != 1 || result.Conflicts[0].Name != want[0].Name ||
+		!slices.Equal(result.Conflicts[0].Addresses, want[0].Addresses) {
+		t.Errorf("conflicts %v, want %v", result.Conflicts, want)
+	}
+}
+
+func TestScanResolveRelocs(t *testing.T) {
+	data := place(0x40,
+		[]byte{
+			0x11, 0x22, 0x33, 0x44,
+			0xD0, 0x48, 0x00, 0x0C, // jal 0x80012340
+			0x01, 0x80, 0x02, 0x3C, // lui $v0, 0x8001
+			0x55, 0x66, 0x77, 0x88,
+		}, 0x10)
+	result := scanTest(t, data, Options{ResolveRelocs: true}, Signature{
+		Name:      "JAL.OBJ",
+		Signature: "11 22 33 44 ?? ?? ?? ?? ?? ?? 02 3C 55 66 77 88",
+		Labels:    []Labels{{Name: "Caller"}},
+	})
+	if len(result.Matches) != 1 {
+		t.Fatalf("%d matches, want 1", len(result.Matches))
+	}
+	want := []ResolvedReloc{
+		{Address: testBase + 0x14, Value: 0x0C0048D0, Target: 0x80012340},
+		{Address: testBase + 0x18, Value: 0x3C028001},
+	}
+	if got := result.Matches[0].Relocs; !slices.Equal(got, want) {
+		t.Errorf("relocs %+v, want %+v", got, want)
+	}
+	wantSymbol := Symbol{Name: "func_80012340", Address: 0x80012340, Kind: SymbolReloc}
+	if !slices.Contains(result.Symbols, wantSymbol) {
+		t.Errorf("symbols %v, want %v among them", result.Symbols, wantSymbol)
+	}
+}
+
+func TestScanAmbiguous(t *testing.T) {
+	data := place(0x80, []byte{0xDE, 0xAD, 0xBE, 0xEF}, 0x10, 0x40)
+	copy(data[0x60:], []byte{0x01, 0x02, 0x03, 0x04})
+	items := []Signature{
+		{Name: "SHORT.OBJ", Signature: "DE AD BE EF", Labels: []Labels{{Name: "Short"}}},
+		{Name: "UNIQUE.OBJ", Signature: "01 02 03 04", Labels: []Labels{{Name: "Unique"}}},
+	}
+	for _, exclude := range []bool{false, true} {
+		result := scanTest(t, data, Options{ExcludeAmbiguous: exclude}, items...)
+		if len(result.Ambiguities) != 1 || result.Ambiguities[0].Name != "SHORT.OBJ" ||
+			!slices.Equal(result.Ambiguities[0].Starts, []int{0x10, 0x40}) {
+			t.Errorf("exclude %v: ambiguities %+v, want SHORT.OBJ at 0x10 and 0x40", exclude, result.Ambiguities)
+		}
+		if len(result.Matches) != 3 {
+			t.Fatalf("exclude %v: %d matches, want 3", exclude, len(result.Matches))
+		}
+		for _, m := range result.Matches {
+			if m.Ambiguous != (m.Name == "SHORT.OBJ") {
+				t.Errorf("exclude %v: %s at 0x%X ambiguous %v", exclude, m.Name, m.Start, m.Ambiguous)
+			}
+		}
+		var names []string
+		for _, symbol := range result.Symbols {
+			names = append(names, symbol.Name)
+		}
+		want := []string{"Short", "Short", "Unique"}
+		if exclude {
+			want = []string{"Unique"}
+		}
+		if !slices.Equal(names, want) {
+			t.Errorf("exclude %v: symbols %v, want %v", exclude, names, want)
+		}
+	}
+}
+
+func TestScanVersion(t *testing.T) {
+	data := place(0x40, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66}, 0x10)
+	provider := StaticProvider{"400": {
+		{Name: "LONG.OBJ", Signature: "11 22 33 44 55 66", Labels: []Labels{{Name: "Long"}, {Name: "loc_1", Offset: 4}}},
+		{Name: "INNER.OBJ", Signature: "33 44", Labels: []Labels{{Name: "Inner"}}},
+	}}
+	matches, err := ScanVersion(data, testBase, "400", provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// both are returned, although they overlap, and loc_ labels are left out
+	if len(matches) != 2 || matches[0].Name != "LONG.OBJ" || matches[1].Name != "INNER.OBJ" {
+		t.Fatalf("matches %+v, want LONG.OBJ then INNER.OBJ", matches)
+	}
+	if want := map[uint32]string{testBase + 0x10: "Long"}; !maps.Equal(matches[0].Symbols, want) {
+		t.Errorf("symbols %v, want %v", matches[0].Symbols, want)
+	}
+	if _, err := ScanVersion(data, testBase, "440", provider); err == nil {
+		t.Error("no error for a version without signatures")
+	}
+}
+
+func TestScanSnapEnds(t *testing.T) {
+	data := place(0x100, []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}, 0x10)
+	copy(data[0x20:], []byte{0x21, 0x22, 0x23, 0x24, 0x25, 0x26, 0x27, 0x28})
+	copy(data[0x80:], []byte{0x31, 0x32, 0x33, 0x34, 0x35, 0x36, 0x37, 0x38})
+	copy(data[0x88:], []byte{0x41, 0x42, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48})
+	items := []Signature{
+		{Name: "A.OBJ", Signature: "11 22 33 44 55 66 77 88"},
+		{Name: "B.OBJ", Signature: "21 22 23 24 25 26 27 28"},
+		{Name: "C.OBJ", Signature: "31 32 33 34 35 36 37 38"},
+		{Name: "D.OBJ", Signature: "41 42 43 44 45 46 47 48"},
+	}
+	tests := []struct {
+		name string
+		snap bool
+		ends []int
+	}{
+		{"off", false, []int{0x18, 0x28, 0x88, 0x90}},
+		// A is padded up to B and C already touches D. The unidentified
+		// code between B and C stays a gap.
+		{"on", true, []int{0x20, 0x28, 0x88, 0x90}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := scanTest(t, data, Options{SnapEnds: tt.snap}, items...)
+			var ends []int
+			for _, m := range result.Matches {
+				ends = append(ends, m.End)
+			}
+			if !slices.Equal(starts(result.Matches), []int{0x10, 0x20, 0x80, 0x88}) || !slices.Equal(ends, tt.ends) {
+				t.Errorf("matches %v-%v, want ends %v", starts(result.Matches), ends, tt.ends)
+			}
+		})
+	}
+}
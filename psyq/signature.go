@@ -0,0 +1,214 @@
+package psyq
+
+import (
+	"encoding/hex"
+	"fmt"
+	"path"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Labels is a symbol defined by an object, Offset bytes from its start.
+type Labels struct {
+	Name   string `json:"name"`
+	Offset uint32 `json:"offset"`
+}
+
+// Signature is the byte pattern of a PSY-Q object, with the labels it
+// defines. Signatures must go through ParseSignatures, or be returned by a
+// SignatureProvider, before they can be matched.
+type Signature struct {
+	Name      string   `json:"name"`
+	Signature string   `json:"sig"`
+	Labels    []Labels `json:"labels,omitempty"`
+	Bss       []Labels `json:"xbss,omitempty"`
+	Library   string   `json:"-"` // library the object is archived in, from the file name
+	signature []byte
+	wildcard  []bool
+	anchor    int       // start of the longest run of non-wildcard bytes
+	anchorLen int       // length of that run
+	skip      *[256]int // bad-character skip table of the run, see compile
+	concrete  int       // number of non-wildcard bytes
+	relocs    []Reloc   // runs of wildcard bytes
+}
+
+// Reloc is a run of wildcard bytes in a signature, masking an operand the
+// linker relocated. Offset is from the start of the signature.
+type Reloc struct {
+	Offset int `json:"offset"`
+	Size   int `json:"size"`
+}
+
+// Relocs returns the relocated ranges of a parsed signature.
+func (s *Signature) Relocs() []Reloc {
+	return s.relocs
+}
+
+// NormalizeObjectName returns the canonical form of an object or library
+// name, so that "libgpu.obj", "LIBGPU.OBJ" and "lib/LIBGPU.OBJ" all compare
+// equal regardless of where the name came from.
+func NormalizeObjectName(name string) string {
+	name = strings.ReplaceAll(name, "\\", "/")
+	return strings.ToUpper(path.Base(name))
+}
+
+// ParseSignatures flattens the signatures of every library, keyed by the
+// file they were read from, and decodes their hex strings into the byte
+// and wildcard masks used by the matcher. They are returned sorted by
+// library, then by object name.
+func ParseSignatures(libraries map[string][]Signature) ([]Signature, error) {
+	return parseSignatures(libraries, nil)
+}
+
+// parseSignatures is ParseSignatures, passing the signatures failing to
+// parse to skip rather than failing when it is not nil.
+func parseSignatures(libraries map[string][]Signature, skip func(error)) ([]Signature, error) {
+	files := make([]string, 0, len(libraries))
+	for file := range libraries {
+		files = append(files, file)
+	}
+	sort.Strings(files)
+	var signatures []Signature
+	for _, file := range files {
+		library := NormalizeObjectName(strings.TrimSuffix(file, path.Ext(file)))
+		for _, signature := range libraries[file] {
+			signature.Library = library
+			if err := signature.parse(); err != nil {
+				err = fmt.Errorf("%s: %w", file, err)
+				if skip == nil {
+					return nil, err
+				}
+				skip(err)
+				continue
+			}
+			signatures = append(signatures, signature)
+		}
+	}
+	sort.SliceStable(signatures, func(i, j int) bool {
+		if signatures[i].Library != signatures[j].Library {
+			return signatures[i].Library < signatures[j].Library
+		}
+		return NormalizeObjectName(signatures[i].Name) < NormalizeObjectName(signatures[j].Name)
+	})
+	return signatures, nil
+}
+
+// ParsedSignature is the decoded form of a Signature, as the matcher sees
+// it. Bytes is the hex encoded pattern, with zeros where Wildcard is set.
+type ParsedSignature struct {
+	Name     string   `json:"name"`
+	Library  string   `json:"library"`
+	Bytes    string   `json:"bytes"`
+	Wildcard []bool   `json:"wildcard"`
+	Concrete int      `json:"concrete"`
+	Relocs   []Reloc  `json:"relocs,omitempty"`
+	Labels   []Labels `json:"labels,omitempty"`
+	Bss      []Labels `json:"xbss,omitempty"`
+}
+
+// Parsed returns the decoded form of a parsed signature.
+func (s *Signature) Parsed() ParsedSignature {
+	return ParsedSignature{
+		Name:     s.Name,
+		Library:  s.Library,
+		Bytes:    hex.EncodeToString(s.signature),
+		Wildcard: s.wildcard,
+		Concrete: s.concrete,
+		Relocs:   s.relocs,
+		Labels:   s.Labels,
+		Bss:      s.Bss,
+	}
+}
+
+// Signature rebuilds the parsed signature p was decoded from.
+func (p ParsedSignature) Signature() (Signature, error) {
+	b, err := hex.DecodeString(p.Bytes)
+	if err != nil {
+		return Signature{}, fmt.Errorf("%s: %w", p.Name, err)
+	}
+	if len(b) != len(p.Wildcard) {
+		return Signature{}, fmt.Errorf("%s: %d bytes but %d wildcard flags", p.Name, len(b), len(p.Wildcard))
+	}
+	tokens := make([]string, len(b))
+	for i, c := range b {
+		if p.Wildcard[i] {
+			tokens[i] = "??"
+			b[i] = 0
+		} else {
+			tokens[i] = fmt.Sprintf("%02X", c)
+		}
+	}
+	s := Signature{
+		Name:      p.Name,
+		Signature: strings.Join(tokens, " "),
+		Labels:    p.Labels,
+		Bss:       p.Bss,
+		Library:   p.Library,
+		signature: b,
+		wildcard:  slices.Clone(p.Wildcard),
+	}
+	s.compile()
+	return s, nil
+}
+
+func (s *Signature) parse() error {
+	s.signature = nil
+	s.wildcard = nil
+	for _, ch := range strings.Split(s.Signature, " ") {
+		pos := len(s.signature)
+		if ch == "??" {
+			s.wildcard = append(s.wildcard, true)
+			s.signature = append(s.signature, 0)
+			continue
+		}
+		if ch == "" {
+			continue
+		}
+		b, err := strconv.ParseUint(ch, 16, 8)
+		if err != nil {
+			return fmt.Errorf("signature %s: invalid byte %q at position %d", s.Name, ch, pos)
+		}
+		s.wildcard = append(s.wildcard, false)
+		s.signature = append(s.signature, byte(b))
+	}
+	s.compile()
+	return nil
+}
+
+// compile precomputes the Boyer-Moore-Horspool skip table used by
+// nextMatch, based on the longest run of non-wildcard bytes of the
+// signature. Signatures without such a run are matched naively. It also
+// records the runs of wildcard bytes as relocations.
+func (s *Signature) compile() {
+	s.anchor, s.anchorLen, s.skip, s.concrete, s.relocs = 0, 0, nil, 0, nil
+	for _, wildcard := range s.wildcard {
+		if !wildcard {
+			s.concrete++
+		}
+	}
+	for i := 0; i < len(s.signature); {
+		j := i
+		for j < len(s.signature) && s.wildcard[j] == s.wildcard[i] {
+			j++
+		}
+		if s.wildcard[i] {
+			s.relocs = append(s.relocs, Reloc{Offset: i, Size: j - i})
+		} else if j-i > s.anchorLen {
+			s.anchor, s.anchorLen = i, j-i
+		}
+		i = j
+	}
+	if s.anchorLen == 0 {
+		return
+	}
+	anchor := s.signature[s.anchor : s.anchor+s.anchorLen]
+	s.skip = new([256]int)
+	for c := range s.skip {
+		s.skip[c] = len(anchor)
+	}
+	for i, c := range anchor[:len(anchor)-1] {
+		s.skip[c] = len(anchor) - 1 - i
+	}
+}
@@ -0,0 +1,69 @@
+package psyq
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestNormalizeObjectName(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{"LIBGPU.OBJ", "LIBGPU.OBJ"},
+		{"libgpu.obj", "LIBGPU.OBJ"},
+		{"LibGpu.Obj", "LIBGPU.OBJ"},
+		{"lib/LIBGPU.OBJ", "LIBGPU.OBJ"},
+		{"psyq/lib/libgpu.obj", "LIBGPU.OBJ"},
+		{`lib\libgpu.obj`, "LIBGPU.OBJ"},
+		{"libgpu", "LIBGPU"},
+	} {
+		if got := NormalizeObjectName(tt.name); got != tt.want {
+			t.Errorf("NormalizeObjectName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParsedSignatureRoundTrip(t *testing.T) {
+	data := place(0x100, []byte{0x11, 0x22, 0x33, 0x44, 0xAA, 0xBB, 0xCC, 0xDD, 0x55, 0x66, 0x77, 0x88}, 0x10, 0x80)
+	items := []Signature{
+		{Name: "EXACT.OBJ", Signature: "11 22 33 44", Labels: []Labels{{Name: "Exact"}}},
+		{Name: "RELOC.OBJ", Signature: "11 22 33 44 ?? ?? ?? ?? 55 66 77 88", Labels: []Labels{{Name: "Reloc", Offset: 8}}, Bss: []Labels{{Name: "relocBss", Offset: 0x90000}}},
+		{Name: "LEAD.OBJ", Signature: "?? 22 33 44"},
+		{Name: "TRAIL.OBJ", Signature: "55 66 77 88 ?? ??", Labels: []Labels{{Name: "Trail"}}},
+		{Name: "MISS.OBJ", Signature: "DE AD BE EF", Labels: []Labels{{Name: "Miss"}}},
+		{Name: "WILD.OBJ", Signature: "?? ?? ?? ??", Labels: []Labels{{Name: "Wild"}}},
+	}
+	signatures, err := ParseSignatures(map[string][]Signature{"LIBBENCH.json": items})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sig := range signatures {
+		// through JSON, as -json-signatures writes them
+		b, err := json.Marshal(sig.Parsed())
+		if err != nil {
+			t.Fatal(err)
+		}
+		var parsed ParsedSignature
+		if err := json.Unmarshal(b, &parsed); err != nil {
+			t.Fatal(err)
+		}
+		back, err := parsed.Signature()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if back.Signature != sig.Signature || back.Library != sig.Library {
+			t.Errorf("%s: rebuilt as %q of %s", sig.Name, back.Signature, back.Library)
+		}
+		opts := matchOptions{relocs: true, labels: labelFilter{exclude: DefaultExcludePrefixes}}
+		want := getMatches(data, 0, "t", []Signature{sig}, opts)
+		if len(want) == 0 && sig.Name != "MISS.OBJ" {
+			t.Errorf("%s: not found", sig.Name)
+		}
+		got := getMatches(data, 0, "t", []Signature{back}, opts)
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: matches %+v, want %+v", sig.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package psyq
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// streamChunkSize is how many bytes every window of ScanReader moves on by.
+const streamChunkSize = 4 << 20
+
+// ScanReader is like Scan, reading the data from r rather than holding it
+// all in memory. The signatures of every version are fetched first, then r
+// is matched in windows overlapping by the length of the longest signature
+// minus one, so the matches across two windows are found once. Offsets are
+// from the start of the stream. ConfidenceThreshold is ignored, the
+// coverage being only known at the end.
+func ScanReader(r io.Reader, baseAddr uint32, opts Options) (*Result, error) {
+	return ScanReaderContext(context.Background(), r, baseAddr, opts)
+}
+
+// ScanReaderContext is like ScanReader, aborting when ctx is done.
+func ScanReaderContext(ctx context.Context, r io.Reader, baseAddr uint32, opts Options) (*Result, error) {
+	return scanStream(ctx, r, baseAddr, opts, streamChunkSize)
+}
+
+func scanStream(ctx context.Context, r io.Reader, baseAddr uint32, opts Options, chunk int) (*Result, error) {
+	if opts.Provider == nil {
+		return nil, errors.New("psyq: no signature provider")
+	}
+	opts = opts.withDefaults()
+	matchOpts := opts.matchOptions()
+	db, err := LoadDatabase(ctx, opts.Provider, opts.Versions, opts.Concurrency)
+	if err != nil {
+		return nil, err
+	}
+	slots := make([]scanned, len(opts.Versions))
+	longest := 1
+	for i, ver := range opts.Versions {
+		slots[i].signatures = db.signatures[ver]
+		for _, sig := range slots[i].signatures {
+			longest = max(longest, len(sig.signature))
+		}
+	}
+
+	// buf holds a window: the chunk it owns, then the start of the next
+	// one. Only the matches starting in the owned chunk are kept, the
+	// others being found again, whole, by the next window.
+	buf := make([]byte, chunk+longest-1)
+	sem := make(chan struct{}, opts.Concurrency)
+	pos, n := 0, 0
+	for {
+		read, err := io.ReadFull(r, buf[n:])
+		n += read
+		last := errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+		if err != nil && !last {
+			return nil, err
+		}
+		owned := chunk
+		if last {
+			owned = n
+		}
+		eg, ctx := errgroup.WithContext(ctx)
+		eg.SetLimit(opts.Concurrency)
+		for i, ver := range opts.Versions {
+			eg.Go(func() error {
+				window := baseAddr + uint32(pos)
+				matches, err := getMatchesParallel(ctx, buf[:n], window, ver, slots[i].signatures, matchOpts, sem)
+				if err != nil {
+					return err
+				}
+				for _, m := range matches {
+					if m.Start >= owned {
+						continue
+					}
+					m.Start += pos
+					m.End += pos
+					slots[i].matches = append(slots[i].matches, m)
+				}
+				return nil
+			})
+		}
+		if err := eg.Wait(); err != nil {
+			return nil, err
+		}
+		if last {
+			break
+		}
+		n = copy(buf, buf[chunk:n])
+		pos += chunk
+	}
+	return mergeScans(slots, pos+n, baseAddr, opts), nil
+}
@@ -0,0 +1,60 @@
+package psyq
+
+import (
+	"bytes"
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestScanStreamChunks(t *testing.T) {
+	pattern := []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88}
+	// ending and starting on the boundaries of chunks of 0x40, across one,
+	// and at the very end
+	data := place(0x400, pattern, 0x38, 0x40, 0x100, 0x17E, 0x3F8)
+	items := []Signature{{Name: "EDGE.OBJ", Signature: "11 22 33 44 ?? 66 77 88", Labels: []Labels{{Name: "Edge", Offset: 4}}}}
+	// objects of several lengths, some longer than the chunks, next to
+	// each other and repeated
+	mixed := place(0x1000, []byte{0xA0, 0xA1, 0xA2, 0xA3}, 0x0, 0x333, 0xFFC)
+	long := make([]byte, 0x50)
+	for i := range long {
+		long[i] = byte(0x10 + i)
+	}
+	copy(mixed[0x4:], long)
+	copy(mixed[0x7C0:], long)
+	copy(mixed[0x810:], []byte{0xB0, 0xB1, 0xB2, 0xB3, 0x00, 0x00, 0xB6, 0xB7, 0xB8, 0xB9, 0xBA, 0xBB})
+	mixedItems := []Signature{
+		{Name: "SHORT.OBJ", Signature: "A0 A1 A2 A3", Labels: []Labels{{Name: "Short"}}},
+		{Name: "LONG.OBJ", Signature: "10 11 12 13 " + strings.Repeat("?? ", 0x48) + "5C 5D 5E 5F", Labels: []Labels{{Name: "Long", Offset: 0x20}}},
+		{Name: "WILD.OBJ", Signature: "B0 B1 B2 B3 ?? ?? B6 B7 B8 B9 BA BB ?? ??"},
+	}
+	tests := []struct {
+		name   string
+		data   []byte
+		items  []Signature
+		chunks []int
+	}{
+		{"boundaries", data, items, []int{1, 7, 8, 0x40, 0x3F, 0x41, 0x3FC, 0x400, 0x1000}},
+		{"mixed", mixed, mixedItems, []int{1, 7, 0x40, 0x333, 0x7C8, 0x1000}},
+	}
+	for _, tt := range tests {
+		opts := Options{Provider: StaticProvider{"t": tt.items}, Versions: []string{"t"}}
+		want, err := Scan(tt.data, testBase, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(want.Matches) == 0 {
+			t.Fatalf("%s: nothing to find", tt.name)
+		}
+		for _, chunk := range tt.chunks {
+			got, err := scanStream(context.Background(), bytes.NewReader(tt.data), testBase, opts, chunk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("%s: chunks of 0x%X: matches at %X, want %X", tt.name, chunk, starts(got.Matches), starts(want.Matches))
+			}
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"time"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+// maxUploadSize bounds the executables accepted by the scan endpoint.
+const maxUploadSize = 64 << 20
+
+// readHeaderTimeout bounds how long a client may take to send its request
+// headers, so idle connections do not pile up.
+const readHeaderTimeout = 10 * time.Second
+
+// server implements the HTTP mode of -serve.
+type server struct {
+	provider psyq.SignatureProvider
+	opts     options
+	versions []string
+	timeout  time.Duration // of a single scan
+	maxSize  int64         // of an upload, maxUploadSize when zero
+}
+
+// serve loads the signatures of every version to scan into a database, then
+// serves scan requests on addr until ctx is done, without fetching again.
+func serve(ctx context.Context, addr string, provider psyq.SignatureProvider, opts options, timeout time.Duration) error {
+	preload, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	db, err := psyq.LoadDatabase(preload, provider, opts.versions, opts.concurrency)
+	if err != nil {
+		return err
+	}
+	s := &server{
+		provider: db,
+		opts:     opts,
+		versions: db.Versions(),
+		timeout:  timeout,
+	}
+	srv := &http.Server{Addr: addr, Handler: s.handler(), ReadHeaderTimeout: readHeaderTimeout}
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		srv.Shutdown(shutdown)
+	}()
+	slog.Info("serving", "addr", addr)
+	if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handler routes the requests to the endpoints of the server.
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /scan", s.handleScan)
+	mux.HandleFunc("GET /versions", s.handleVersions)
+	return mux
+}
+
+// handleScan scans the executable sent as the request body, or as the file
+// field of a multipart form, and answers with the JSON result.
+func (s *server) handleScan(w http.ResponseWriter, r *http.Request) {
+	maxSize := s.maxSize
+	if maxSize == 0 {
+		maxSize = maxUploadSize
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize)
+	data, err := readUpload(r)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		http.Error(w, fmt.Sprintf("upload larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if s.opts.scanImage {
+		data, err = selectImageExe(data, s.opts.exeIndex)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	text, baseAddr, regions, err := selectText(data, s.opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	opts := s.opts
+	opts.dataRegions = regions
+	ctx, cancel := context.WithTimeout(r.Context(), s.timeout)
+	defer cancel()
+	logger := slog.Default().With("remote", r.RemoteAddr)
+	result, err := psyq.ScanContext(ctx, text, baseAddr, scanOptions(s.provider, opts, logger))
+	if err != nil {
+		logger.Error(err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSONResponse(w, result)
+}
+
+// readUpload returns the executable of a scan request.
+func readUpload(r *http.Request) ([]byte, error) {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "multipart/form-data" {
+		return io.ReadAll(r.Body)
+	}
+	f, _, err := r.FormFile("file")
+	if err != nil {
+		return nil, fmt.Errorf("multipart upload without a file field: %w", err)
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// handleVersions answers with the SDK versions scanned by the server.
+func (s *server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	writeJSONResponse(w, s.versions)
+}
+
+func writeJSONResponse(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		slog.Warn("unable to write the response", "err", err)
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/xeeynamo/go-psyq-signatures/psyq"
+)
+
+func testServer(t *testing.T, maxSize int64) *httptest.Server {
+	t.Helper()
+	s := &server{
+		provider: psyq.StaticProvider{"400": {testPattern}},
+		opts:     options{versions: []string{"400"}},
+		versions: []string{"400"},
+		timeout:  time.Minute,
+		maxSize:  maxSize,
+	}
+	srv := httptest.NewServer(s.handler())
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// post sends body to the scan endpoint, returning the status and body.
+func post(t *testing.T, srv *httptest.Server, contentType string, body []byte) (int, []byte) {
+	t.Helper()
+	resp, err := http.Post(srv.URL+"/scan", contentType, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, b
+}
+
+func TestServeScan(t *testing.T) {
+	srv := testServer(t, 0)
+	exe := testExe{text: withCode(0x100, 0x40)}.build()
+	var form bytes.Buffer
+	mw := multipart.NewWriter(&form)
+	fw, err := mw.CreateFormFile("file", "test.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(exe)
+	mw.Close()
+	for _, tt := range []struct {
+		name        string
+		contentType string
+		body        []byte
+	}{
+		{"raw", "application/octet-stream", exe},
+		{"multipart", mw.FormDataContentType(), form.Bytes()},
+	} {
+		status, body := post(t, srv, tt.contentType, tt.body)
+		if status != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", tt.name, status, body)
+		}
+		var result psyq.Result
+		if err := json.Unmarshal(body, &result); err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Matches) != 1 || result.Matches[0].Start != 0x40 {
+			t.Errorf("%s: matches %+v, want TEST.OBJ at 0x40", tt.name, result.Matches)
+		}
+	}
+}
+
+func TestServeErrors(t *testing.T) {
+	srv := testServer(t, 0x1000)
+	for _, tt := range []struct {
+		name string
+		body []byte
+		want int
+	}{
+		{"not an exe", []byte("hello"), http.StatusBadRequest},
+		{"too large", testExe{text: withCode(0x2000, 0x40)}.build(), http.StatusRequestEntityTooLarge},
+	} {
+		if status, body := post(t, srv, "application/octet-stream", tt.body); status != tt.want {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, status, tt.want, body)
+		}
+	}
+	resp, err := http.Get(srv.URL + "/scan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /scan: status %d, want %d", resp.StatusCode, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestServeVersions(t *testing.T) {
+	srv := testServer(t, 0)
+	resp, err := http.Get(srv.URL + "/versions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var versions []string
+	if err := json.NewDecoder(resp.Body).Decode(&versions); err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(versions, []string{"400"}) {
+		t.Errorf("versions %v, want [400]", versions)
+	}
+}